@@ -0,0 +1,104 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// StageBuffer - output buffer of a stage
+type StageBuffer struct {
+	// Size - capacity, must be > 0: the next stage takes one item ahead of
+	// its job (see stageInput), so an unbuffered hand-off is not possible
+	Size int
+	// DropOldest - full buffer loses its oldest item instead of blocking the stage
+	DropOldest bool
+}
+
+// StageMetrics - backpressure stats of a stage output
+type StageMetrics struct {
+	Name string
+	// Sent - items produced by the stage, Dropped of them never reached the next stage
+	Sent    uint64
+	Dropped uint64
+	// Blocked - time the stage waited for room in its output buffer
+	Blocked time.Duration
+	// MaxQueued - high-water mark of the output buffer
+	MaxQueued int
+}
+
+// PipelineMetrics - filled by ExecutePipelineWith, safe to read while pipeline runs.
+// Collecting them puts a pump after every stage, which holds one more item in flight.
+type PipelineMetrics struct {
+	mu     sync.Mutex
+	stages []StageMetrics
+}
+
+// Stages - snapshot of per-stage metrics
+func (pm *PipelineMetrics) Stages() []StageMetrics {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	stages := make([]StageMetrics, len(pm.stages))
+	copy(stages, pm.stages)
+	return stages
+}
+
+func (pm *PipelineMetrics) reset(names []string) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.stages = make([]StageMetrics, len(names))
+	for i, name := range names {
+		pm.stages[i].Name = name
+	}
+}
+
+func (pm *PipelineMetrics) update(i int, f func(sm *StageMetrics)) {
+	if pm == nil {
+		return
+	}
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	f(&pm.stages[i])
+}
+
+// pump - moves items from stage to its output buffer, counting blocked time
+// and dropping the oldest buffered items if asked to. Pump holds one item in flight,
+// so the stage may run one item ahead of the buffer size.
+func pump(from <-chan interface{}, to chan interface{}, buf StageBuffer, metrics *PipelineMetrics, idx int) {
+	defer close(to)
+	for dataRaw := range from {
+		var dropped uint64
+		var blocked time.Duration
+		for pending := true; pending; {
+			select {
+			case to <- dataRaw:
+				pending = false
+				continue
+			default:
+			}
+
+			switch {
+			case !buf.DropOldest:
+				start := time.Now()
+				to <- dataRaw
+				blocked += time.Since(start)
+				pending = false
+			default:
+				select {
+				case <-to:
+					dropped++
+				default:
+				}
+			}
+		}
+
+		queued := len(to)
+		metrics.update(idx, func(sm *StageMetrics) {
+			sm.Sent++
+			sm.Dropped += dropped
+			sm.Blocked += blocked
+			if queued > sm.MaxQueued {
+				sm.MaxQueued = queued
+			}
+		})
+	}
+}
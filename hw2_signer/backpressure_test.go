@@ -0,0 +1,103 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"hw2_signer/pipetest"
+)
+
+// drainAfter - consumer which does not read until release is closed
+func drainAfter(release <-chan struct{}, received *[]int) job {
+	return job(func(in, out chan interface{}) {
+		<-release
+		for dataRaw := range in {
+			*received = append(*received, dataRaw.(int))
+		}
+	})
+}
+
+func TestBackpressureBlockMetrics(t *testing.T) {
+	metrics := &PipelineMetrics{}
+	opts := PipelineOptions{
+		StageNames: []string{"source", "sink"},
+		Buffers:    []StageBuffer{{Size: 1}},
+		Metrics:    metrics,
+	}
+	received := 0
+	pipetest.Run(t, 5*time.Second, func() {
+		ExecutePipelineWith(opts,
+			numbersJob(10),
+			job(func(in, out chan interface{}) {
+				for range in {
+					time.Sleep(5 * time.Millisecond)
+					received++
+				}
+			}),
+		)
+	})
+
+	if received != 10 {
+		t.Errorf("expected 10 items, got %d", received)
+	}
+	stages := metrics.Stages()
+	if len(stages) != 2 || stages[0].Name != "source" || stages[1].Name != "sink" {
+		t.Fatalf("unexpected stages %+v", stages)
+	}
+	source := stages[0]
+	if source.Sent != 10 || source.Dropped != 0 || source.MaxQueued > 1 {
+		t.Errorf("unexpected source metrics %+v", source)
+	}
+	// slow sink keeps the source waiting for most of its items
+	if source.Blocked < 20*time.Millisecond {
+		t.Errorf("expected source to be blocked, got %s", source.Blocked)
+	}
+}
+
+func TestBackpressureDropOldest(t *testing.T) {
+	for _, size := range []int{1, 2} {
+		metrics := &PipelineMetrics{}
+		opts := PipelineOptions{
+			Buffers: []StageBuffer{{Size: size, DropOldest: true}},
+			Metrics: metrics,
+		}
+		release := make(chan struct{})
+		received := []int{}
+		pipetest.Run(t, 5*time.Second, func() {
+			ExecutePipelineWith(opts,
+				job(func(in, out chan interface{}) {
+					for i := 0; i < 10; i++ {
+						out <- i
+					}
+					// producer never blocks on a full buffer
+					close(release)
+				}),
+				drainAfter(release, &received),
+			)
+		})
+
+		source := metrics.Stages()[0]
+		if source.Sent != 10 || int(source.Dropped)+len(received) != 10 {
+			t.Errorf("size %d: unexpected metrics %+v, received %v", size, source, received)
+		}
+		if len(received) == 0 || received[len(received)-1] != 9 {
+			t.Errorf("size %d: newest item must survive, received %v", size, received)
+		}
+		if source.Blocked != 0 {
+			t.Errorf("size %d: drop oldest must not block, blocked %s", size, source.Blocked)
+		}
+	}
+}
+
+func TestBackpressureZeroSize(t *testing.T) {
+	ran := false
+	err := ExecutePipelineWith(PipelineOptions{Buffers: []StageBuffer{{Size: 1}, {Size: 0}}},
+		numbersJob(10),
+		job(func(in, out chan interface{}) {
+			ran = true
+		}),
+	)
+	if err == nil || ran {
+		t.Errorf("expected error before any stage runs, got %v, ran %v", err, ran)
+	}
+}
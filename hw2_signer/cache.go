@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// SignerFunc - signature shared by DataSignerMd5 and DataSignerCrc32
+type SignerFunc func(data string) string
+
+// CacheStats - hit/miss counters of a SignerCache
+type CacheStats struct {
+	Hits     uint64
+	Misses   uint64
+	DiskHits uint64
+}
+
+type cacheEntry struct {
+	key   string
+	value string
+}
+
+type diskRecord struct {
+	Key   string `json:"k"`
+	Value string `json:"v"`
+}
+
+type diskPos struct {
+	offset int64
+	length int
+}
+
+// SignerCache - memoizes signer results in an in-memory LRU,
+// optionally backed by an append-only file which survives restarts
+type SignerCache struct {
+	mu    sync.Mutex
+	size  int
+	ll    *list.List
+	items map[string]*list.Element
+	stats CacheStats
+
+	file    *os.File
+	fileEnd int64
+	index   map[string]diskPos
+}
+
+// NewSignerCache - creates cache holding up to size entries in memory,
+// empty path disables the on-disk store
+func NewSignerCache(size int, path string) (*SignerCache, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("cache size must be > 0")
+	}
+	sc := &SignerCache{
+		size:  size,
+		ll:    list.New(),
+		items: make(map[string]*list.Element, size),
+	}
+	if path == "" {
+		return sc, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	sc.file = file
+	sc.index = make(map[string]diskPos)
+	if err := sc.loadIndex(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return sc, nil
+}
+
+func (sc *SignerCache) loadIndex() error {
+	reader := bufio.NewReader(sc.file)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// incomplete tail of a crashed run is dropped
+			break
+		}
+		if err != nil {
+			return err
+		}
+		rec := diskRecord{}
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return fmt.Errorf("cache file corrupted at offset %d: %s", offset, err)
+		}
+		sc.index[rec.Key] = diskPos{offset: offset, length: len(line)}
+		offset += int64(len(line))
+	}
+	sc.fileEnd = offset
+	return sc.file.Truncate(offset)
+}
+
+// cacheKey - signer name keeps results of different signers apart in a shared cache,
+// salt is read on every call so changing DataSignerSalt never returns stale hashes
+func cacheKey(name, data string) string {
+	return strconv.Itoa(len(name)) + ":" + name + strconv.Itoa(len(data)) + ":" + data + DataSignerSalt
+}
+
+// Wrap - returns signer which consults the cache before calling f, name tells
+// f from other signers sharing the cache, e.g. "md5" or "crc32"
+func (sc *SignerCache) Wrap(name string, f SignerFunc) SignerFunc {
+	return func(data string) string {
+		key := cacheKey(name, data)
+		if value, ok := sc.get(key); ok {
+			return value
+		}
+		value := f(data)
+		sc.put(key, value)
+		return value
+	}
+}
+
+// WrapSigner - Wrap for a Signer, name is the one of NewSignerByName.
+// Failed calls are not cached.
+func (sc *SignerCache) WrapSigner(name string, s Signer) Signer {
+	return SignerErrFunc(func(data string) (string, error) {
+		key := cacheKey(name, data)
+		if value, ok := sc.get(key); ok {
+			return value, nil
+		}
+		value, err := s.Sign(data)
+		if err != nil {
+			return "", err
+		}
+		sc.put(key, value)
+		return value, nil
+	})
+}
+
+func (sc *SignerCache) get(key string) (string, bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if el, ok := sc.items[key]; ok {
+		sc.ll.MoveToFront(el)
+		sc.stats.Hits++
+		return el.Value.(*cacheEntry).value, true
+	}
+
+	if pos, ok := sc.index[key]; ok {
+		value, err := sc.readDisk(pos)
+		if err == nil {
+			sc.stats.Hits++
+			sc.stats.DiskHits++
+			sc.add(key, value)
+			return value, true
+		}
+		fmt.Println("SC: cant read cache file:", err)
+	}
+
+	sc.stats.Misses++
+	return "", false
+}
+
+func (sc *SignerCache) readDisk(pos diskPos) (string, error) {
+	buf := make([]byte, pos.length)
+	if _, err := sc.file.ReadAt(buf, pos.offset); err != nil {
+		return "", err
+	}
+	rec := diskRecord{}
+	if err := json.Unmarshal(buf, &rec); err != nil {
+		return "", err
+	}
+	return rec.Value, nil
+}
+
+func (sc *SignerCache) put(key, value string) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sc.add(key, value)
+	if sc.file == nil {
+		return
+	}
+	if _, ok := sc.index[key]; ok {
+		return
+	}
+	line, err := json.Marshal(diskRecord{Key: key, Value: value})
+	if err != nil {
+		fmt.Println("SC: cant pack cache record:", err)
+		return
+	}
+	line = append(line, '\n')
+	if _, err := sc.file.WriteAt(line, sc.fileEnd); err != nil {
+		fmt.Println("SC: cant write cache file:", err)
+		return
+	}
+	sc.index[key] = diskPos{offset: sc.fileEnd, length: len(line)}
+	sc.fileEnd += int64(len(line))
+}
+
+func (sc *SignerCache) add(key, value string) {
+	if el, ok := sc.items[key]; ok {
+		el.Value.(*cacheEntry).value = value
+		sc.ll.MoveToFront(el)
+		return
+	}
+	sc.items[key] = sc.ll.PushFront(&cacheEntry{key: key, value: value})
+	if sc.ll.Len() > sc.size {
+		oldest := sc.ll.Back()
+		sc.ll.Remove(oldest)
+		delete(sc.items, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// Stats - returns snapshot of hit/miss counters
+func (sc *SignerCache) Stats() CacheStats {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.stats
+}
+
+// Len - number of entries held in memory
+func (sc *SignerCache) Len() int {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.ll.Len()
+}
+
+// Close - flushes and closes the on-disk store
+func (sc *SignerCache) Close() error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.file == nil {
+		return nil
+	}
+	err := sc.file.Sync()
+	if cerr := sc.file.Close(); err == nil {
+		err = cerr
+	}
+	sc.file = nil
+	sc.index = nil
+	return err
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func countingSigner(calls *uint32) SignerFunc {
+	return func(data string) string {
+		atomic.AddUint32(calls, 1)
+		return "h(" + data + DataSignerSalt + ")"
+	}
+}
+
+func TestSignerCacheLRU(t *testing.T) {
+	var calls uint32
+	sc, err := NewSignerCache(2, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	signer := sc.Wrap("counting", countingSigner(&calls))
+
+	for _, data := range []string{"a", "b", "a", "c", "b"} {
+		if res := signer(data); res != "h("+data+")" {
+			t.Errorf("bad result for %s: %s", data, res)
+		}
+	}
+
+	// "b" was evicted by "c" because "a" was used more recently
+	if calls != 4 {
+		t.Errorf("expected 4 signer calls, got %d", calls)
+	}
+	stats := sc.Stats()
+	if stats.Hits != 1 || stats.Misses != 4 {
+		t.Errorf("bad stats: %+v", stats)
+	}
+	if sc.Len() != 2 {
+		t.Errorf("expected 2 entries in memory, got %d", sc.Len())
+	}
+}
+
+func TestSignerCacheSalt(t *testing.T) {
+	defer func(salt string) { DataSignerSalt = salt }(DataSignerSalt)
+
+	var calls uint32
+	sc, _ := NewSignerCache(10, "")
+	signer := sc.Wrap("counting", countingSigner(&calls))
+
+	signer("1")
+	DataSignerSalt = "salt"
+	if res := signer("1"); res != "h(1salt)" {
+		t.Errorf("stale result after salt change: %s", res)
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 signer calls, got %d", calls)
+	}
+}
+
+func TestSignerCacheDisk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signer_cache")
+	if err != nil {
+		t.Fatalf("cant create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "crc32.cache")
+
+	var calls uint32
+	sc, err := NewSignerCache(1, path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	signer := sc.Wrap("counting", countingSigner(&calls))
+	signer("x")
+	signer("y")
+	// "x" is gone from memory but still on disk
+	signer("x")
+	if calls != 2 {
+		t.Errorf("expected 2 signer calls, got %d", calls)
+	}
+	if err := sc.Close(); err != nil {
+		t.Fatalf("cant close cache: %s", err)
+	}
+
+	sc, err = NewSignerCache(10, path)
+	if err != nil {
+		t.Fatalf("cant reopen cache: %s", err)
+	}
+	defer sc.Close()
+	signer = sc.Wrap("counting", countingSigner(&calls))
+	if res := signer("y"); res != "h(y)" {
+		t.Errorf("bad result from disk: %s", res)
+	}
+	if calls != 2 {
+		t.Errorf("expected no signer calls after reopen, got %d", calls-2)
+	}
+	if stats := sc.Stats(); stats.DiskHits != 1 || stats.Misses != 0 {
+		t.Errorf("bad stats: %+v", stats)
+	}
+}
+
+func TestSignerCacheNames(t *testing.T) {
+	sc, _ := NewSignerCache(10, "")
+	md5 := sc.Wrap("md5", func(data string) string { return "md5(" + data + ")" })
+	crc32 := sc.Wrap("crc32", func(data string) string { return "crc32(" + data + ")" })
+	if md5("1") != "md5(1)" || crc32("1") != "crc32(1)" {
+		t.Errorf("signers sharing a cache got each other's hashes")
+	}
+
+	sha256 := sc.WrapSigner("sha256", NewSHA256Signer())
+	xxhash := sc.WrapSigner("xxhash", NewXXHashSigner())
+	first, _ := sha256.Sign("1")
+	second, _ := xxhash.Sign("1")
+	if cached, _ := sha256.Sign("1"); first == second || cached != first {
+		t.Errorf("unexpected hashes %s, %s, cached %s", first, second, cached)
+	}
+	if stats := sc.Stats(); stats.Hits != 1 || stats.Misses != 4 {
+		t.Errorf("bad stats: %+v", stats)
+	}
+}
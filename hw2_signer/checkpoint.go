@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+)
+
+type checkpointValue struct {
+	Type  string `json:"t"`
+	Value string `json:"v"`
+}
+
+type checkpointRecord struct {
+	Stage  string            `json:"stage"`
+	Item   string            `json:"item"`
+	Output []checkpointValue `json:"out"`
+}
+
+// checkpointHeader - first line of a checkpoint file
+type checkpointHeader struct {
+	// Fingerprint - nil in files of older versions, which had no header
+	Fingerprint *string `json:"fingerprint"`
+}
+
+// Checkpoint - persists outputs of per-item stages, so a restarted run
+// skips items which were already processed
+type Checkpoint struct {
+	mu   sync.Mutex
+	file *os.File
+	done map[string][]interface{}
+}
+
+// OpenCheckpoint - opens or creates checkpoint file, records of the previous runs are loaded.
+// fingerprint identifies what made the outputs (see PipelineFingerprint), a file
+// written with another fingerprint is refused instead of replaying stale hashes.
+func OpenCheckpoint(path, fingerprint string) (*Checkpoint, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	cp := &Checkpoint{
+		file: file,
+		done: make(map[string][]interface{}),
+	}
+	if err := cp.load(fingerprint); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return cp, nil
+}
+
+func (cp *Checkpoint) load(fingerprint string) error {
+	reader := bufio.NewReader(cp.file)
+	var offset int64
+	line, err := reader.ReadBytes('\n')
+	if err == io.EOF {
+		// new file, or a run which crashed before writing its header
+		return cp.writeHeader(fingerprint)
+	}
+	if err != nil {
+		return err
+	}
+	header := checkpointHeader{}
+	if err := json.Unmarshal(line, &header); err != nil || header.Fingerprint == nil {
+		return fmt.Errorf("checkpoint has no header, remove it to start over")
+	}
+	if *header.Fingerprint != fingerprint {
+		return fmt.Errorf("checkpoint was made with other salt, key or signers, remove it to start over")
+	}
+	offset += int64(len(line))
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// incomplete tail of a crashed run is dropped
+			break
+		}
+		if err != nil {
+			return err
+		}
+		rec := checkpointRecord{}
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return fmt.Errorf("checkpoint corrupted at offset %d: %s", offset, err)
+		}
+		output := make([]interface{}, 0, len(rec.Output))
+		for _, cv := range rec.Output {
+			value, err := decodeCheckpointValue(cv)
+			if err != nil {
+				return fmt.Errorf("checkpoint corrupted at offset %d: %s", offset, err)
+			}
+			output = append(output, value)
+		}
+		cp.done[checkpointKey(rec.Stage, rec.Item)] = output
+		offset += int64(len(line))
+	}
+	if err := cp.file.Truncate(offset); err != nil {
+		return err
+	}
+	_, err = cp.file.Seek(offset, io.SeekStart)
+	return err
+}
+
+func (cp *Checkpoint) writeHeader(fingerprint string) error {
+	line, err := json.Marshal(checkpointHeader{Fingerprint: &fingerprint})
+	if err != nil {
+		return err
+	}
+	if err := cp.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := cp.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = cp.file.Write(append(line, '\n'))
+	return err
+}
+
+func checkpointKey(stage, item string) string {
+	return stage + "\x00" + item
+}
+
+func encodeCheckpointValue(value interface{}) (checkpointValue, bool) {
+	switch v := value.(type) {
+	case int:
+		return checkpointValue{Type: "int", Value: strconv.Itoa(v)}, true
+	case string:
+		return checkpointValue{Type: "string", Value: v}, true
+	}
+	return checkpointValue{}, false
+}
+
+func decodeCheckpointValue(cv checkpointValue) (interface{}, error) {
+	switch cv.Type {
+	case "int":
+		return strconv.Atoi(cv.Value)
+	case "string":
+		return cv.Value, nil
+	}
+	return nil, fmt.Errorf("unknown value type %s", cv.Type)
+}
+
+// Len - number of completed items of all stages
+func (cp *Checkpoint) Len() int {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return len(cp.done)
+}
+
+func (cp *Checkpoint) lookup(stage, item string) ([]interface{}, bool) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	output, ok := cp.done[checkpointKey(stage, item)]
+	return output, ok
+}
+
+func (cp *Checkpoint) save(stage, item string, output []interface{}) error {
+	rec := checkpointRecord{Stage: stage, Item: item, Output: make([]checkpointValue, 0, len(output))}
+	for _, value := range output {
+		cv, ok := encodeCheckpointValue(value)
+		if !ok {
+			// failed or unsupported items are processed again on restart
+			return nil
+		}
+		rec.Output = append(rec.Output, cv)
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if _, ok := cp.done[checkpointKey(stage, item)]; ok {
+		return nil
+	}
+	if _, err := cp.file.Write(line); err != nil {
+		return err
+	}
+	cp.done[checkpointKey(stage, item)] = output
+	return nil
+}
+
+// Stage - makes per-item stage resumable, j is run separately for every item
+// which is not in the checkpoint yet, so its outputs can be attributed to the input.
+// j must keep shared state (e.g. Exclusive signers) outside, like jobs of SingleHashWith and MultiHashWith do.
+func (cp *Checkpoint) Stage(name string, j job) job {
+	return func(in, out chan interface{}) {
+		wg := &sync.WaitGroup{}
+		for dataRaw := range in {
+			key, ok := itemKey(dataRaw)
+			if !ok {
+				// errors and unknown values go through untouched
+				out <- dataRaw
+				continue
+			}
+			if output, ok := cp.lookup(name, key); ok {
+				for _, value := range output {
+					out <- value
+				}
+				continue
+			}
+
+			wg.Add(1)
+			dataRaw, key := dataRaw, key
+			goWorker(out, dataRaw, func(out chan<- interface{}) {
+				defer wg.Done()
+				output := runItem(j, dataRaw, func(value interface{}) {
+					out <- value
+				})
+				if err := cp.save(name, key, output); err != nil {
+					fmt.Println("CP: cant save checkpoint:", err)
+				}
+			})
+		}
+		wg.Wait()
+	}
+}
+
+// Close - flushes and closes checkpoint file
+func (cp *Checkpoint) Close() error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	err := cp.file.Sync()
+	if cerr := cp.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
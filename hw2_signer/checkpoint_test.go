@@ -0,0 +1,180 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func runCheckpointed(cp *Checkpoint, checksum Signer, inputData []int) string {
+	singleHash := SingleHashWith(NewSHA256Signer(), checksum)
+	multiHash := MultiHashWith(checksum)
+	if cp != nil {
+		singleHash = cp.Stage("SingleHash", singleHash)
+		multiHash = cp.Stage("MultiHash", multiHash)
+	}
+
+	var result string
+	ExecutePipeline(
+		job(func(in, out chan interface{}) {
+			for _, num := range inputData {
+				out <- num
+			}
+		}),
+		singleHash,
+		multiHash,
+		job(CombineResults),
+		job(func(in, out chan interface{}) {
+			result, _ = (<-in).(string)
+		}),
+	)
+	return result
+}
+
+func TestCheckpointResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signer_checkpoint")
+	if err != nil {
+		t.Fatalf("cant create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "run.checkpoint")
+
+	var calls uint32
+	checksum := SignerErrFunc(func(data string) (string, error) {
+		atomic.AddUint32(&calls, 1)
+		return NewXXHashSigner().Sign(data)
+	})
+	inputData := []int{0, 1, 1, 2, 3, 5, 8}
+	expected := runCheckpointed(nil, checksum, inputData)
+
+	// interrupted run which got only part of the input
+	cp, err := OpenCheckpoint(path, "test")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	runCheckpointed(cp, checksum, inputData[:3])
+	if err := cp.Close(); err != nil {
+		t.Fatalf("cant close checkpoint: %s", err)
+	}
+
+	// simulate crash in the middle of writing a record
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("cant open checkpoint: %s", err)
+	}
+	file.WriteString(`{"stage":"SingleHash","item":"int:`)
+	file.Close()
+
+	cp, err = OpenCheckpoint(path, "test")
+	if err != nil {
+		t.Fatalf("cant reopen checkpoint: %s", err)
+	}
+	defer cp.Close()
+	// SingleHash and MultiHash of 0 and 1
+	if cp.Len() != 4 {
+		t.Errorf("expected 4 completed items, got %d", cp.Len())
+	}
+
+	calls = 0
+	result := runCheckpointed(cp, checksum, inputData)
+	if result != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, expected)
+	}
+	// 2, 3, 5, 8 are new: 2 checksums in SingleHash and 6 in MultiHash for every one
+	if calls != 4*8 {
+		t.Errorf("expected %d checksum calls, got %d", 4*8, calls)
+	}
+}
+
+func TestCheckpointPassesErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signer_checkpoint")
+	if err != nil {
+		t.Fatalf("cant create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cp, err := OpenCheckpoint(filepath.Join(dir, "run.checkpoint"), "test")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer cp.Close()
+
+	failing := SignerErrFunc(func(data string) (string, error) { return "", errRemote })
+	var stageErrs uint32
+	ExecutePipeline(
+		job(func(in, out chan interface{}) {
+			out <- "1"
+		}),
+		cp.Stage("MultiHash", MultiHashWith(failing)),
+		job(func(in, out chan interface{}) {
+			for dataRaw := range in {
+				if _, ok := dataRaw.(*StageError); ok {
+					stageErrs++
+				}
+			}
+		}),
+	)
+	if stageErrs != 1 {
+		t.Errorf("expected 1 stage error, got %d", stageErrs)
+	}
+	if cp.Len() != 0 {
+		t.Errorf("failed items must not be checkpointed")
+	}
+}
+
+func TestCheckpointFingerprint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signer_checkpoint")
+	if err != nil {
+		t.Fatalf("cant create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "run.checkpoint")
+
+	cfg := DefaultPipelineConfig()
+	fingerprint := PipelineFingerprint(cfg, "salt", []byte("key"))
+	explicit := PipelineConfig{Stages: []StageConfig{
+		{Name: "SingleHash", Signers: []string{"md5", "crc32"}},
+		{Name: "MultiHash", Signers: []string{"crc32"}},
+		{Name: "CombineResults"},
+	}}
+	if PipelineFingerprint(explicit, "salt", []byte("key")) != fingerprint {
+		t.Errorf("default signers must have the same fingerprint as explicit ones")
+	}
+	others := map[string]string{
+		"salt":    PipelineFingerprint(cfg, "other", []byte("key")),
+		"key":     PipelineFingerprint(cfg, "salt", []byte("other")),
+		"signers": PipelineFingerprint(PipelineConfig{Stages: []StageConfig{{Name: "SingleHash", Signers: []string{"sha256", "crc32"}}}}, "salt", []byte("key")),
+	}
+	for name, other := range others {
+		if other == fingerprint {
+			t.Errorf("other %s must change the fingerprint", name)
+		}
+	}
+
+	cp, err := OpenCheckpoint(path, fingerprint)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	runCheckpointed(cp, NewXXHashSigner(), []int{0, 1})
+	cp.Close()
+
+	if _, err := OpenCheckpoint(path, others["salt"]); err == nil {
+		t.Errorf("checkpoint of other settings must be refused")
+	}
+	cp, err = OpenCheckpoint(path, fingerprint)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cp.Len() != 4 {
+		t.Errorf("expected 4 completed items, got %d", cp.Len())
+	}
+	cp.Close()
+
+	// checkpoints of older versions had no header
+	ioutil.WriteFile(path, []byte(`{"stage":"SingleHash","item":"int:0","out":[]}`+"\n"), 0644)
+	if _, err := OpenCheckpoint(path, fingerprint); err == nil {
+		t.Errorf("checkpoint without header must be refused")
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock - time source of signers and their wrappers
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+}
+
+// SignerClock - clock used by DataSigner* functions, overheat logic and signer wrappers,
+// tests replace it with FakeClock
+var SignerClock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+type fakeSleeper struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+// FakeClock - virtual time which moves only by Advance, sleeping goroutines
+// wake up when their deadline is reached. BlockUntil waits for a known number
+// of sleepers, tests of whole pipelines find idle moments with testing/synctest.
+type FakeClock struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	now      time.Time
+	sleepers []*fakeSleeper
+}
+
+// NewFakeClock -
+func NewFakeClock(start time.Time) *FakeClock {
+	fc := &FakeClock{now: start}
+	fc.cond = sync.NewCond(&fc.mu)
+	return fc
+}
+
+// Now -
+func (fc *FakeClock) Now() time.Time {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.now
+}
+
+// After - channel fires once virtual time reaches now+d
+func (fc *FakeClock) After(d time.Duration) <-chan time.Time {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- fc.now
+		return ch
+	}
+	fc.sleepers = append(fc.sleepers, &fakeSleeper{deadline: fc.now.Add(d), ch: ch})
+	fc.cond.Broadcast()
+	return ch
+}
+
+// Sleep - blocks until virtual time is advanced by d
+func (fc *FakeClock) Sleep(d time.Duration) {
+	<-fc.After(d)
+}
+
+// Sleepers - number of pending Sleep and After calls
+func (fc *FakeClock) Sleepers() int {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return len(fc.sleepers)
+}
+
+// BlockUntil - waits until at least n Sleep or After calls are pending
+func (fc *FakeClock) BlockUntil(n int) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	for len(fc.sleepers) < n {
+		fc.cond.Wait()
+	}
+}
+
+// Advance - moves virtual time forward and wakes up sleepers whose deadline passed
+func (fc *FakeClock) Advance(d time.Duration) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.advanceTo(fc.now.Add(d))
+}
+
+// AdvanceToNext - moves virtual time to the nearest deadline, false if nobody sleeps
+func (fc *FakeClock) AdvanceToNext() bool {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if len(fc.sleepers) == 0 {
+		return false
+	}
+	next := fc.sleepers[0].deadline
+	for _, s := range fc.sleepers {
+		if s.deadline.Before(next) {
+			next = s.deadline
+		}
+	}
+	fc.advanceTo(next)
+	return true
+}
+
+func (fc *FakeClock) advanceTo(t time.Time) {
+	if t.After(fc.now) {
+		fc.now = t
+	}
+	// wake up in deadline order
+	sort.SliceStable(fc.sleepers, func(i, j int) bool {
+		return fc.sleepers[i].deadline.Before(fc.sleepers[j].deadline)
+	})
+	pending := fc.sleepers[:0]
+	for _, s := range fc.sleepers {
+		if s.deadline.After(fc.now) {
+			pending = append(pending, s)
+			continue
+		}
+		s.ch <- fc.now
+	}
+	fc.sleepers = pending
+	fc.cond.Broadcast()
+}
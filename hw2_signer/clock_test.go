@@ -0,0 +1,189 @@
+package main
+
+import (
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	fc := NewFakeClock(time.Unix(0, 0))
+	woke := make(chan time.Time, 2)
+	go func() {
+		fc.Sleep(time.Second)
+		woke <- fc.Now()
+	}()
+	go func() {
+		fc.Sleep(10 * time.Millisecond)
+		woke <- fc.Now()
+	}()
+
+	fc.BlockUntil(2)
+	fc.Advance(5 * time.Millisecond)
+	select {
+	case <-woke:
+		t.Fatalf("sleeper woke up too early")
+	default:
+	}
+
+	if !fc.AdvanceToNext() {
+		t.Fatalf("expected pending sleepers")
+	}
+	if at := <-woke; at != time.Unix(0, 0).Add(10*time.Millisecond) {
+		t.Errorf("unexpected wake up time %s", at)
+	}
+	fc.Advance(time.Hour)
+	<-woke
+	if fc.Sleepers() != 0 || fc.AdvanceToNext() {
+		t.Errorf("expected no sleepers")
+	}
+}
+
+// functions of common.go, TestSigner replaces them with its own ones
+var (
+	commonOverheatLock    = OverheatLock
+	commonOverheatUnlock  = OverheatUnlock
+	commonDataSignerMd5   = DataSignerMd5
+	commonDataSignerCrc32 = DataSignerCrc32
+)
+
+// useFakeClock - switches signers of common.go to a fake clock, call returned func to restore
+func useFakeClock() (*FakeClock, func()) {
+	clock, lock, unlock, md5, crc32 := SignerClock, OverheatLock, OverheatUnlock, DataSignerMd5, DataSignerCrc32
+	fc := NewFakeClock(time.Unix(0, 0))
+	SignerClock = fc
+	OverheatLock, OverheatUnlock = commonOverheatLock, commonOverheatUnlock
+	DataSignerMd5, DataSignerCrc32 = commonDataSignerMd5, commonDataSignerCrc32
+	return fc, func() {
+		SignerClock, OverheatLock, OverheatUnlock, DataSignerMd5, DataSignerCrc32 = clock, lock, unlock, md5, crc32
+	}
+}
+
+// runOnFakeClock - runs f in a synctest bubble, moving virtual time of fc to the
+// next deadline whenever goroutines of f are all durably blocked, returns virtual
+// duration of f. Everything f blocks on must be made inside of it.
+func runOnFakeClock(t *testing.T, fc *FakeClock, f func()) time.Duration {
+	var elapsed time.Duration
+	synctest.Test(t, func(t *testing.T) {
+		start := fc.Now()
+		done := make(chan struct{})
+		go func() {
+			f()
+			close(done)
+		}()
+		for {
+			synctest.Wait()
+			select {
+			case <-done:
+				elapsed = fc.Now().Sub(start)
+				// goroutines f left sleeping, e.g. timed out signers, must end with the bubble
+				for fc.AdvanceToNext() {
+					synctest.Wait()
+				}
+				return
+			default:
+			}
+			if !fc.AdvanceToNext() {
+				// nothing sleeps on fc, synctest reports f if it is deadlocked
+				<-done
+			}
+		}
+	})
+	return elapsed
+}
+
+func TestRunOnFakeClockBusyProcess(t *testing.T) {
+	fc, restore := useFakeClock()
+	defer restore()
+
+	// goroutine outside of f never blocks, it must not keep the clock still
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+		}
+	}()
+	elapsed := runOnFakeClock(t, fc, func() {
+		fc.Sleep(time.Hour)
+	})
+	if elapsed != time.Hour {
+		t.Errorf("expected 1h, got %s", elapsed)
+	}
+}
+
+func TestSignerOnFakeClock(t *testing.T) {
+	fc, restore := useFakeClock()
+	defer restore()
+
+	testExpected := "1173136728138862632818075107442090076184424490584241521304_1696913515191343735512658979631549563179965036907783101867_27225454331033649287118297354036464389062965355426795162684_29568666068035183841425683795340791879727309630931025356555_3994492081516972096677631278379039212655368881548151736_4958044192186797981418233587017209679042592862002427381542_4958044192186797981418233587017209679042592862002427381542"
+	testResult := "NOT_SET"
+	inputData := []int{0, 1, 1, 2, 3, 5, 8}
+
+	elapsed := runOnFakeClock(t, fc, func() {
+		ExecutePipeline(
+			job(func(in, out chan interface{}) {
+				for _, fibNum := range inputData {
+					out <- fibNum
+				}
+			}),
+			job(SingleHash),
+			job(MultiHash),
+			job(CombineResults),
+			job(func(in, out chan interface{}) {
+				testResult, _ = (<-in).(string)
+			}),
+		)
+	})
+
+	if testExpected != testResult {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", testResult, testExpected)
+	}
+	// md5 calls go one by one, then crc32 of the last md5 and 6 crc32 in MultiHash
+	expectedTime := 7*10*time.Millisecond + 2*time.Second
+	if elapsed != expectedTime {
+		t.Errorf("unexpected virtual time\nGot: %s\nExpected: %s", elapsed, expectedTime)
+	}
+}
+
+func TestOverheatOnFakeClock(t *testing.T) {
+	fc, restore := useFakeClock()
+	defer restore()
+
+	// two md5 at once overheat the signer
+	elapsed := runOnFakeClock(t, fc, func() {
+		done := make(chan struct{})
+		for i := 0; i < 2; i++ {
+			go func() {
+				DataSignerMd5("1")
+				done <- struct{}{}
+			}()
+		}
+		<-done
+		<-done
+	})
+	if elapsed <= time.Second {
+		t.Errorf("expected overheat delay, got %s", elapsed)
+	}
+}
+
+func TestWithTimeoutOnFakeClock(t *testing.T) {
+	fc, restore := useFakeClock()
+	defer restore()
+
+	slow := Fallible(func(data string) string {
+		fc.Sleep(time.Minute)
+		return data
+	})
+	var err error
+	elapsed := runOnFakeClock(t, fc, func() {
+		_, err = WithTimeout(slow, time.Second)("1")
+	})
+	if err != ErrSignerTimeout || elapsed != time.Second {
+		t.Errorf("expected timeout after 1s, got %v after %s", err, elapsed)
+	}
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// max length of a single part emitted by CombineExternal
+const combinePartLen = 64 << 10
+
+// combineFanIn - max runs merged at once, so merges never run out of file descriptors
+var combineFanIn = 64
+
+func combine(items []string) string {
+	sort.Strings(items)
+	var buffer bytes.Buffer
+	for i := 0; i < len(items); i++ {
+		if i != 0 {
+			buffer.WriteString("_")
+		}
+		buffer.WriteString(items[i])
+	}
+	return buffer.String()
+}
+
+// CombineWindows - streaming CombineResults, every window of size items
+// or of interval (zero or less disables either limit) is combined and sent
+// on its own. Interval is measured by SignerClock.
+func CombineWindows(size int, interval time.Duration) job {
+	if size < 0 {
+		size = 0
+	}
+	return func(in, out chan interface{}) {
+		window := make([]string, 0, size)
+		flush := func() {
+			if len(window) != 0 {
+				out <- combine(window)
+				window = make([]string, 0, size)
+			}
+		}
+
+		var tick <-chan time.Time
+		if interval > 0 {
+			tick = SignerClock.After(interval)
+		}
+
+		for {
+			select {
+			case dataRaw, ok := <-in:
+				if !ok {
+					flush()
+					return
+				}
+				if err, ok := dataRaw.(error); ok {
+					out <- err
+					continue
+				}
+				dataStr, ok := dataRaw.(string)
+				if !ok {
+					fmt.Println("CW: cant convert result data to string")
+					continue
+				}
+				window = append(window, dataStr)
+				if size > 0 && len(window) >= size {
+					flush()
+				}
+			case <-tick:
+				flush()
+				tick = SignerClock.After(interval)
+			}
+		}
+	}
+}
+
+// CombineExternal - CombineResults for inputs which do not fit in memory.
+// At most chunkSize items are kept, sorted chunks are spilled to temp files in dir
+// (os.TempDir() if empty) and merged. Result is sent in parts of bounded length,
+// their concatenation is equal to the output of CombineResults.
+// chunkSize must be > 0, otherwise the stage fails.
+func CombineExternal(chunkSize int, dir string) job {
+	return func(in, out chan interface{}) {
+		runs := []string{}
+		defer func() {
+			for _, run := range runs {
+				os.Remove(run)
+			}
+		}()
+
+		// after a failure input is still drained, so upstream stages do not block
+		var failed error
+		fail := func(err error) {
+			failed = err
+			out <- &StageError{Stage: "CombineExternal", Err: err}
+		}
+		if chunkSize <= 0 {
+			fail(fmt.Errorf("chunk size must be > 0, got %d", chunkSize))
+			chunkSize = 0
+		}
+		chunk := make([]string, 0, chunkSize)
+		for dataRaw := range in {
+			if err, ok := dataRaw.(error); ok {
+				out <- err
+				continue
+			}
+			if failed != nil {
+				continue
+			}
+			dataStr, ok := dataRaw.(string)
+			if !ok {
+				fmt.Println("CE: cant convert result data to string")
+				continue
+			}
+			chunk = append(chunk, dataStr)
+			if len(chunk) >= chunkSize {
+				run, err := spillRun(chunk, dir)
+				if err != nil {
+					fail(err)
+					continue
+				}
+				runs = append(runs, run)
+				chunk = chunk[:0]
+			}
+		}
+		if failed != nil {
+			return
+		}
+
+		if len(runs) == 0 {
+			sort.Strings(chunk)
+			emitParts(out, &sliceRun{items: chunk})
+			return
+		}
+		if len(chunk) != 0 {
+			run, err := spillRun(chunk, dir)
+			if err != nil {
+				fail(err)
+				return
+			}
+			runs = append(runs, run)
+		}
+		if err := mergeRuns(out, &runs, dir); err != nil {
+			fail(err)
+		}
+	}
+}
+
+// spillRun - writes sorted chunk to a temp file, one quoted item per line
+func spillRun(chunk []string, dir string) (string, error) {
+	sort.Strings(chunk)
+	file, err := ioutil.TempFile(dir, "combine")
+	if err != nil {
+		return "", err
+	}
+	writer := bufio.NewWriter(file)
+	for _, item := range chunk {
+		writer.WriteString(strconv.Quote(item))
+		writer.WriteByte('\n')
+	}
+	err = writer.Flush()
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+type sortedRun interface {
+	next() (string, bool, error)
+}
+
+type sliceRun struct {
+	items []string
+}
+
+func (sr *sliceRun) next() (string, bool, error) {
+	if len(sr.items) == 0 {
+		return "", false, nil
+	}
+	item := sr.items[0]
+	sr.items = sr.items[1:]
+	return item, true, nil
+}
+
+type fileRun struct {
+	scanner *bufio.Scanner
+}
+
+func (fr *fileRun) next() (string, bool, error) {
+	if !fr.scanner.Scan() {
+		return "", false, fr.scanner.Err()
+	}
+	item, err := strconv.Unquote(fr.scanner.Text())
+	return item, err == nil, err
+}
+
+type runHead struct {
+	item string
+	run  sortedRun
+}
+
+type runHeap []runHead
+
+func (h runHeap) Len() int            { return len(h) }
+func (h runHeap) Less(i, j int) bool  { return h[i].item < h[j].item }
+func (h runHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(runHead)) }
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	head := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return head
+}
+
+// mergedRun - k-way merge of sorted runs
+type mergedRun struct {
+	heads runHeap
+}
+
+func (mr *mergedRun) next() (string, bool, error) {
+	if len(mr.heads) == 0 {
+		return "", false, nil
+	}
+	head := mr.heads[0]
+	item, ok, err := head.run.next()
+	if err != nil {
+		return "", false, err
+	}
+	if ok {
+		mr.heads[0].item = item
+		heap.Fix(&mr.heads, 0)
+	} else {
+		heap.Pop(&mr.heads)
+	}
+	return head.item, true, nil
+}
+
+// openRuns - k-way merge of run files, close must be called once it is done
+func openRuns(runs []string) (merged *mergedRun, close func(), err error) {
+	files := make([]*os.File, 0, len(runs))
+	close = func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}
+	merged = &mergedRun{heads: make(runHeap, 0, len(runs))}
+	for _, run := range runs {
+		file, err := os.Open(run)
+		if err != nil {
+			close()
+			return nil, nil, err
+		}
+		files = append(files, file)
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 0, 64<<10), combinePartLen*16)
+		fr := &fileRun{scanner: scanner}
+		item, ok, err := fr.next()
+		if err != nil {
+			close()
+			return nil, nil, err
+		}
+		if ok {
+			merged.heads = append(merged.heads, runHead{item: item, run: fr})
+		}
+	}
+	heap.Init(&merged.heads)
+	return merged, close, nil
+}
+
+// mergeToFile - merges runs into a new run file in dir
+func mergeToFile(runs []string, dir string) (string, error) {
+	merged, close, err := openRuns(runs)
+	if err != nil {
+		return "", err
+	}
+	defer close()
+	file, err := ioutil.TempFile(dir, "combine")
+	if err != nil {
+		return "", err
+	}
+	writer := bufio.NewWriter(file)
+	for {
+		item, ok, err := merged.next()
+		if err != nil || !ok {
+			if err == nil {
+				err = writer.Flush()
+			}
+			if cerr := file.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(file.Name())
+				return "", err
+			}
+			return file.Name(), nil
+		}
+		writer.WriteString(strconv.Quote(item))
+		writer.WriteByte('\n')
+	}
+}
+
+// mergeRuns - merges runs in passes of at most combineFanIn files and emits
+// the result, runs is updated with intermediate files so they are removed too
+func mergeRuns(out chan interface{}, runs *[]string, dir string) error {
+	pending := *runs
+	for len(pending) > combineFanIn {
+		next := []string{}
+		for start := 0; start < len(pending); start += combineFanIn {
+			end := start + combineFanIn
+			if end > len(pending) {
+				end = len(pending)
+			}
+			run, err := mergeToFile(pending[start:end], dir)
+			if err != nil {
+				return err
+			}
+			*runs = append(*runs, run)
+			next = append(next, run)
+			for _, merged := range pending[start:end] {
+				os.Remove(merged)
+			}
+		}
+		pending = next
+	}
+
+	merged, close, err := openRuns(pending)
+	if err != nil {
+		return err
+	}
+	defer close()
+	return emitParts(out, merged)
+}
+
+// emitParts - joins items with "_" and sends the result in parts of about combinePartLen
+func emitParts(out chan interface{}, run sortedRun) error {
+	var buffer bytes.Buffer
+	first := true
+	for {
+		item, ok, err := run.next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		if !first {
+			buffer.WriteString("_")
+		}
+		first = false
+		buffer.WriteString(item)
+		if buffer.Len() >= combinePartLen {
+			out <- buffer.String()
+			buffer.Reset()
+		}
+	}
+	if buffer.Len() != 0 || first {
+		out <- buffer.String()
+	}
+	return nil
+}
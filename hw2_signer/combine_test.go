@@ -0,0 +1,149 @@
+package main
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func collectCombined(items []string, combiner job) []string {
+	results := []string{}
+	ExecutePipeline(
+		job(func(in, out chan interface{}) {
+			for _, item := range items {
+				out <- item
+			}
+		}),
+		combiner,
+		job(func(in, out chan interface{}) {
+			for dataRaw := range in {
+				results = append(results, dataRaw.(string))
+			}
+		}),
+	)
+	return results
+}
+
+func TestCombineWindowsBySize(t *testing.T) {
+	results := collectCombined([]string{"b", "a", "d", "c", "e"}, CombineWindows(2, 0))
+	expected := []string{"a_b", "c_d", "e"}
+	if strings.Join(results, "|") != strings.Join(expected, "|") {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", results, expected)
+	}
+}
+
+func TestCombineWindowsByTime(t *testing.T) {
+	fc, restore := useFakeClock()
+	defer restore()
+
+	results := []string{}
+	runOnFakeClock(t, fc, func() {
+		ExecutePipeline(
+			job(func(in, out chan interface{}) {
+				out <- "b"
+				out <- "a"
+				fc.Sleep(100 * time.Millisecond)
+				out <- "c"
+			}),
+			CombineWindows(0, 30*time.Millisecond),
+			job(func(in, out chan interface{}) {
+				for dataRaw := range in {
+					results = append(results, dataRaw.(string))
+				}
+			}),
+		)
+	})
+	expected := []string{"a_b", "c"}
+	if strings.Join(results, "|") != strings.Join(expected, "|") {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", results, expected)
+	}
+}
+
+func TestCombineExternal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signer_combine")
+	if err != nil {
+		t.Fatalf("cant create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	rnd := rand.New(rand.NewSource(1))
+	items := make([]string, 0, 1000)
+	for i := 0; i < cap(items); i++ {
+		// long items make several output parts
+		items = append(items, strconv.Itoa(rnd.Int())+strings.Repeat("x", rnd.Intn(300))+"\n\"")
+	}
+	expected := collectCombined(items, job(CombineResults))
+
+	for _, chunkSize := range []int{1, 7, 1000, 5000} {
+		parts := collectCombined(items, CombineExternal(chunkSize, dir))
+		if len(parts) < 2 {
+			t.Errorf("chunk %d: expected result in several parts, got %d", chunkSize, len(parts))
+		}
+		if strings.Join(parts, "") != expected[0] {
+			t.Errorf("chunk %d: results not match", chunkSize)
+		}
+		if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+			t.Errorf("chunk %d: %d temp files left", chunkSize, len(files))
+		}
+	}
+
+	// several merge passes
+	defer func(fanIn int) { combineFanIn = fanIn }(combineFanIn)
+	combineFanIn = 3
+	parts := collectCombined(items, CombineExternal(7, dir))
+	if strings.Join(parts, "") != expected[0] {
+		t.Errorf("fan-in 3: results not match")
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("fan-in 3: %d temp files left", len(files))
+	}
+
+	parts = collectCombined(nil, CombineExternal(10, dir))
+	if len(parts) != 1 || parts[0] != "" {
+		t.Errorf("expected single empty result, got %q", parts)
+	}
+}
+
+func TestCombineExternalErrors(t *testing.T) {
+	for name, combiner := range map[string]job{
+		"spill":          CombineExternal(10, "/nonexistent/signer_combine"),
+		"zero chunk":     CombineExternal(0, ""),
+		"negative chunk": CombineExternal(-1, ""),
+	} {
+		done := make(chan struct{})
+		var stageErrs, results int
+		go func() {
+			defer close(done)
+			ExecutePipeline(
+				job(func(in, out chan interface{}) {
+					for i := 0; i < 10*MaxInputDataLen; i++ {
+						out <- strconv.Itoa(i)
+					}
+				}),
+				combiner,
+				job(func(in, out chan interface{}) {
+					for dataRaw := range in {
+						if _, ok := dataRaw.(*StageError); ok {
+							stageErrs++
+						} else {
+							results++
+						}
+					}
+				}),
+			)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: pipeline blocked after error", name)
+		}
+		if stageErrs != 1 || results != 0 {
+			t.Errorf("%s: expected 1 stage error and no results, got %d and %d", name, stageErrs, results)
+		}
+	}
+}
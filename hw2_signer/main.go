@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// usage: signer [-config pipeline.json] [-format text|json] [-salt s] [-key k] [-concurrency n] [-checkpoint file] [-trace file] [file ...]
+// records are read line by line from files or stdin, "-" stands for stdin
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("signer", flag.ContinueOnError)
+	configPath := flags.String("config", "", "pipeline config, SingleHash -> MultiHash -> CombineResults if empty")
+	format := flags.String("format", "text", "output format: text or json")
+	salt := flags.String("salt", "", "salt appended by md5 and crc32 signers")
+	key := flags.String("key", "", "secret for keyed signers (hmac-sha256, blake2b)")
+	concurrency := flags.Int("concurrency", 0, "max parallel calls of every signer, 0 - unlimited")
+	checkpointPath := flags.String("checkpoint", "", "file to save progress to, an interrupted run resumes from it")
+	tracePath := flags.String("trace", "", "file to write per-item trace to, in Chrome trace format")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unknown format %s", *format)
+	}
+
+	cfg := DefaultPipelineConfig()
+	if *configPath != "" {
+		var err error
+		cfg, err = LoadPipelineConfig(*configPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	DataSignerSalt = *salt
+	opts := BuildOptions{Key: []byte(*key), Concurrency: *concurrency}
+	if *checkpointPath != "" {
+		cp, err := OpenCheckpoint(*checkpointPath, PipelineFingerprint(cfg, *salt, opts.Key))
+		if err != nil {
+			return err
+		}
+		defer cp.Close()
+		opts.Checkpoint = cp
+	}
+	var tracer *Tracer
+	if *tracePath != "" {
+		tracer = NewTracer()
+		opts.Trace = true
+	}
+	stages, err := BuildPipeline(cfg, opts)
+	if err != nil {
+		return err
+	}
+
+	inputs, err := openInputs(flags.Args(), stdin)
+	if err != nil {
+		return err
+	}
+	defer closeInputs(inputs, stdin)
+
+	jobs := make([]job, 0, len(stages)+2)
+	jobs = append(jobs, readRecords(inputs))
+	jobs = append(jobs, stages...)
+	jobs = append(jobs, writeResults(stdout, *format))
+	names := []string{"input"}
+	for _, stage := range cfg.Stages {
+		names = append(names, stage.Name)
+	}
+	names = append(names, "output")
+	if err := ExecutePipelineWith(PipelineOptions{StageNames: names, Tracer: tracer}, jobs...); err != nil {
+		fmt.Println("EP: pipeline aborted:", err)
+	}
+
+	if tracer != nil {
+		file, err := os.Create(*tracePath)
+		if err != nil {
+			return err
+		}
+		err = tracer.WriteJSON(file)
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}
+	return nil
+}
+
+func openInputs(paths []string, stdin io.Reader) ([]io.Reader, error) {
+	if len(paths) == 0 {
+		return []io.Reader{stdin}, nil
+	}
+	inputs := make([]io.Reader, 0, len(paths))
+	for _, path := range paths {
+		if path == "-" {
+			inputs = append(inputs, stdin)
+			continue
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			closeInputs(inputs, stdin)
+			return nil, err
+		}
+		inputs = append(inputs, file)
+	}
+	return inputs, nil
+}
+
+func closeInputs(inputs []io.Reader, stdin io.Reader) {
+	for _, input := range inputs {
+		if closer, ok := input.(io.Closer); ok && input != stdin {
+			closer.Close()
+		}
+	}
+}
+
+// readRecords - source stage, numeric lines are sent as int, others as string
+func readRecords(inputs []io.Reader) job {
+	return func(in, out chan interface{}) {
+		for _, input := range inputs {
+			scanner := bufio.NewScanner(input)
+			for scanner.Scan() {
+				line := strings.TrimSpace(scanner.Text())
+				if line == "" {
+					continue
+				}
+				if num, err := strconv.Atoi(line); err == nil {
+					out <- num
+				} else {
+					out <- line
+				}
+			}
+			if err := scanner.Err(); err != nil {
+				out <- &StageError{Stage: "input", Err: err}
+			}
+		}
+	}
+}
+
+type resultLine struct {
+	Result string `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// writeResults - sink stage, prints every value which reaches the end of pipeline
+func writeResults(w io.Writer, format string) job {
+	return func(in, out chan interface{}) {
+		enc := json.NewEncoder(w)
+		for dataRaw := range in {
+			line := resultLine{}
+			if err, ok := dataRaw.(error); ok {
+				line.Error = err.Error()
+			} else {
+				line.Result = fmt.Sprint(dataRaw)
+			}
+
+			if format == "json" {
+				if err := enc.Encode(line); err != nil {
+					fmt.Fprintln(os.Stderr, "cant write result:", err)
+				}
+			} else if line.Error != "" {
+				fmt.Fprintln(os.Stderr, "error:", line.Error)
+			} else {
+				fmt.Fprintln(w, line.Result)
+			}
+		}
+	}
+}
@@ -47,6 +47,10 @@ func TestPipeline(t *testing.T) {
 
 func TestSigner(t *testing.T) {
 
+	// время виртуальное, задержки считает FakeClock, а не часы на стене
+	fc, restore := useFakeClock()
+	defer restore()
+
 	testExpected := "1173136728138862632818075107442090076184424490584241521304_1696913515191343735512658979631549563179965036907783101867_27225454331033649287118297354036464389062965355426795162684_29568666068035183841425683795340791879727309630931025356555_3994492081516972096677631278379039212655368881548151736_4958044192186797981418233587017209679042592862002427381542_4958044192186797981418233587017209679042592862002427381542"
 	testResult := "NOT_SET"
 
@@ -65,7 +69,7 @@ func TestSigner(t *testing.T) {
 		for {
 			if swapped := atomic.CompareAndSwapUint32(&dataSignerOverheat, 0, 1); !swapped {
 				fmt.Println("OverheatLock happend")
-				time.Sleep(time.Second)
+				SignerClock.Sleep(time.Second)
 			} else {
 				break
 			}
@@ -76,7 +80,7 @@ func TestSigner(t *testing.T) {
 		for {
 			if swapped := atomic.CompareAndSwapUint32(&dataSignerOverheat, 1, 0); !swapped {
 				fmt.Println("OverheatUnlock happend")
-				time.Sleep(time.Second)
+				SignerClock.Sleep(time.Second)
 			} else {
 				break
 			}
@@ -88,7 +92,7 @@ func TestSigner(t *testing.T) {
 		defer OverheatUnlock()
 		data += DataSignerSalt
 		dataHash := fmt.Sprintf("%x", md5.Sum([]byte(data)))
-		time.Sleep(10 * time.Millisecond)
+		SignerClock.Sleep(10 * time.Millisecond)
 		return dataHash
 	}
 	DataSignerCrc32 = func(data string) string {
@@ -96,7 +100,7 @@ func TestSigner(t *testing.T) {
 		data += DataSignerSalt
 		crcH := crc32.ChecksumIEEE([]byte(data))
 		dataHash := strconv.FormatUint(uint64(crcH), 10)
-		time.Sleep(time.Second)
+		SignerClock.Sleep(time.Second)
 		return dataHash
 	}
 
@@ -122,11 +126,9 @@ func TestSigner(t *testing.T) {
 		}),
 	}
 
-	start := time.Now()
-
-	ExecutePipeline(hashSignJobs...)
-
-	end := time.Since(start)
+	end := runOnFakeClock(t, fc, func() {
+		ExecutePipeline(hashSignJobs...)
+	})
 
 	expectedTime := 3 * time.Second
 
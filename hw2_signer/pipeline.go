@@ -0,0 +1,495 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strconv"
+	"sync"
+)
+
+// PanicPolicy - what ExecutePipelineWith does when a goroutine the stage started
+// with goWorker panics on its item. Panic of the stage job itself always fails
+// the stage as with PanicAbort, other policies only report it their way too.
+type PanicPolicy int
+
+const (
+	// PanicAbort - stage stops, the rest of its input is discarded, pipeline returns the error
+	PanicAbort PanicPolicy = iota
+	// PanicSkipItem - item is dropped, its *StageError goes downstream instead, stage goes on
+	PanicSkipItem
+	// PanicDeadLetter - like PanicSkipItem, but *StageError is sent to PipelineOptions.DeadLetters
+	PanicDeadLetter
+)
+
+// PipelineOptions - settings of ExecutePipelineWith, zero value behaves like ExecutePipeline
+type PipelineOptions struct {
+	PanicPolicy PanicPolicy
+	// DeadLetters must be read by someone or stages block, required by PanicDeadLetter
+	DeadLetters chan<- *StageError
+	// StageNames are used in errors and metrics, "stage N" by default
+	StageNames []string
+	// Buffers - output buffer of every stage, MaxInputDataLen blocking buffer for missing ones
+	Buffers []StageBuffer
+	// Metrics collects backpressure stats if set
+	Metrics *PipelineMetrics
+	// Tracer records a span of every stage for every item it takes if set
+	Tracer *Tracer
+}
+
+// PanicError - recovered panic, it is Err of the *StageError
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// stageInput - feeds stage through unbuffered channel, so the last forwarded item
+// is the one stage is working on. In a traced pipeline it also unwraps items
+// of the previous stage and passes outputs of the job on with the trace of the
+// item it took last: one goroutine does both, so outputs are never attributed
+// to an item the job took after sending them.
+type stageInput struct {
+	mu       sync.Mutex
+	ch       chan interface{}
+	pause    chan struct{}
+	stop     chan struct{}
+	abort    chan struct{}
+	aborted  sync.Once
+	finished chan struct{}
+	last     interface{}
+	seq      uint64
+	// out - output of the job, the stage output itself if not traced
+	out    chan interface{}
+	tracer *Tracer
+	name   string
+	// spans - requests of goWorker for the span of the current item
+	spans chan chan *Span
+	// relayed - input is over and outputs are passed on
+	relayed chan struct{}
+}
+
+func newStageInput(in, out chan interface{}, tracer *Tracer, name string) *stageInput {
+	si := &stageInput{
+		ch:       make(chan interface{}),
+		pause:    make(chan struct{}),
+		stop:     make(chan struct{}),
+		abort:    make(chan struct{}),
+		finished: make(chan struct{}),
+		out:      out,
+		relayed:  make(chan struct{}),
+	}
+	if tracer != nil {
+		si.out = make(chan interface{})
+		si.tracer, si.name = tracer, name
+		si.spans = make(chan chan *Span)
+	}
+	go si.run(in, out)
+	return si
+}
+
+// run - passes items of in to si.ch until in is over, stop or abort, the rest
+// of in is discarded after abort. Outputs of traced job go to out until si.out is closed.
+func (si *stageInput) run(in, out chan interface{}) {
+	defer close(si.relayed)
+	var outputs chan interface{}
+	if si.tracer != nil {
+		outputs = si.out
+	}
+	var pending interface{}
+	var pendingTrace uint64
+	var span *Span
+	havePending, feeding, draining := false, true, false
+	endInput := func() {
+		feeding = false
+		close(si.ch)
+		close(si.finished)
+	}
+
+	for feeding || draining || outputs != nil {
+		var recv, send chan interface{}
+		var pause, stop, abort chan struct{}
+		if feeding {
+			pause, stop, abort = si.pause, si.stop, si.abort
+			if havePending {
+				send = si.ch
+			} else {
+				recv = in
+			}
+		} else if draining {
+			recv = in
+		}
+
+		select {
+		case dataRaw, ok := <-recv:
+			switch {
+			case !ok && feeding:
+				endInput()
+			case !ok:
+				draining = false
+			case feeding:
+				pending, pendingTrace, havePending = dataRaw, 0, true
+				if item, ok := dataRaw.(tracedItem); ok {
+					pending, pendingTrace = item.value, item.traceID
+				}
+			}
+		case send <- pending:
+			si.mu.Lock()
+			si.last = pending
+			si.seq++
+			si.mu.Unlock()
+			havePending = false
+			if si.tracer != nil {
+				if pendingTrace == 0 {
+					pendingTrace = si.tracer.newTrace(pending)
+				}
+				span.release()
+				span = si.tracer.open(pendingTrace, si.name, pending)
+			}
+		case <-pause:
+		case <-stop:
+			endInput()
+		case <-abort:
+			endInput()
+			// upstream stages finish even though nobody needs their items
+			draining = true
+		case dataRaw, ok := <-outputs:
+			if !ok {
+				outputs = nil
+				continue
+			}
+			if _, ok := dataRaw.(tracedItem); !ok {
+				// the first stage makes items of its own
+				var traceID uint64
+				if span != nil {
+					traceID = span.traceID
+				} else {
+					traceID = si.tracer.newTrace(dataRaw)
+				}
+				dataRaw = tracedItem{traceID: traceID, value: dataRaw}
+			}
+			out <- dataRaw
+		case reply := <-si.spans:
+			if span != nil {
+				span.retain()
+			}
+			reply <- span
+		}
+	}
+	span.release()
+}
+
+// state - last item received by stage, number of received items and whether input is over
+func (si *stageInput) state() (interface{}, uint64, bool) {
+	done := false
+	// forwarder accepts pause only between items, so last and seq are consistent
+	select {
+	case si.pause <- struct{}{}:
+	case <-si.finished:
+		done = true
+	}
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	return si.last, si.seq, done
+}
+
+func (si *stageInput) drain() {
+	for range si.ch {
+	}
+}
+
+// close - stops forwarding when stage is over, items it did not take stay in the input channel
+func (si *stageInput) close() {
+	close(si.stop)
+}
+
+// closeOutput - waits until outputs of the job are passed on, call when job and its workers are over
+func (si *stageInput) closeOutput() {
+	if si.tracer != nil {
+		close(si.out)
+	}
+	<-si.relayed
+}
+
+// abortInput - ends input of the stage, the rest of it is discarded
+func (si *stageInput) abortInput() {
+	si.aborted.Do(func() {
+		close(si.abort)
+	})
+}
+
+// itemKey - identity of int and string items, others can not be checkpointed
+func itemKey(dataRaw interface{}) (string, bool) {
+	switch v := dataRaw.(type) {
+	case int:
+		return "int:" + strconv.Itoa(v), true
+	case string:
+		return "string:" + v, true
+	}
+	return "", false
+}
+
+// stageWorkers - goroutines a stage started with goWorker
+type stageWorkers struct {
+	wg sync.WaitGroup
+	// onPanic - reports panic of worker on data, out is the output of the worker
+	onPanic func(data interface{}, panicErr *PanicError, out chan<- interface{})
+	// spans - requests for the span of the item stage took last, nil if not traced
+	spans chan chan *Span
+}
+
+// workerGroups - stageWorkers of running stages by their output channel,
+// jobs know nothing but their channels
+var workerGroups = struct {
+	sync.Mutex
+	m map[chan<- interface{}]*stageWorkers
+}{m: map[chan<- interface{}]*stageWorkers{}}
+
+func registerWorkers(out chan<- interface{}, onPanic func(data interface{}, panicErr *PanicError, out chan<- interface{})) *stageWorkers {
+	workers := &stageWorkers{onPanic: onPanic}
+	workerGroups.Lock()
+	workerGroups.m[out] = workers
+	workerGroups.Unlock()
+	return workers
+}
+
+// wait - waits for workers of the stage writing to out, out may be closed after it
+func (sw *stageWorkers) wait(out chan<- interface{}) {
+	sw.wg.Wait()
+	workerGroups.Lock()
+	delete(workerGroups.m, out)
+	workerGroups.Unlock()
+}
+
+// span - span of the item the stage took last, held for the caller, nil if not traced
+func (sw *stageWorkers) span() *Span {
+	if sw.spans == nil {
+		return nil
+	}
+	reply := make(chan *Span)
+	sw.spans <- reply
+	return <-reply
+}
+
+func toPanicError(r interface{}) *PanicError {
+	if panicErr, ok := r.(*PanicError); ok {
+		return panicErr
+	}
+	return &PanicError{Value: r, Stack: debug.Stack()}
+}
+
+// goWorker - starts fn processing item data for the stage writing to out,
+// fn sends its results to the out it gets. Inside a pipeline out is closed only
+// after fn finishes, panic of fn is handled by the panic policy like a panic of
+// the stage on data and results of fn keep the trace of the item the stage took
+// last. Outside of pipelines it is a plain goroutine and fn gets out itself.
+func goWorker(out chan<- interface{}, data interface{}, fn func(out chan<- interface{})) {
+	goSpanWorker(out, data, func(out chan<- interface{}, _ *Span) {
+		fn(out)
+	})
+}
+
+// goSpanWorker - goWorker which gets the span of the item as well, nil if not traced
+func goSpanWorker(out chan<- interface{}, data interface{}, fn func(out chan<- interface{}, span *Span)) {
+	workerGroups.Lock()
+	workers := workerGroups.m[out]
+	if workers != nil {
+		workers.wg.Add(1)
+	}
+	workerGroups.Unlock()
+	if workers == nil {
+		go fn(out, nil)
+		return
+	}
+
+	span := workers.span()
+	workerOut := out
+	var traced chan interface{}
+	var relayed chan struct{}
+	if span != nil {
+		traced, relayed = make(chan interface{}), make(chan struct{})
+		workerOut = traced
+		go func() {
+			defer close(relayed)
+			for dataRaw := range traced {
+				out <- tracedItem{traceID: span.traceID, value: dataRaw}
+			}
+		}()
+	}
+	go func() {
+		defer workers.wg.Done()
+		defer span.release()
+		if traced != nil {
+			defer func() {
+				close(traced)
+				<-relayed
+			}()
+		}
+		defer func() {
+			if r := recover(); r != nil {
+				workers.onPanic(data, toPanicError(r), workerOut)
+			}
+		}()
+		fn(workerOut, span)
+	}()
+}
+
+// runItem - runs job over a single item, outputs are forwarded as they appear and returned.
+// Panic of the job or its workers is raised again as *PanicError once it is over.
+func runItem(j job, dataRaw interface{}, forward func(value interface{})) []interface{} {
+	itemIn := make(chan interface{}, 1)
+	itemOut := make(chan interface{}, MaxInputDataLen)
+	itemIn <- dataRaw
+	close(itemIn)
+
+	mu := &sync.Mutex{}
+	var panicErr *PanicError
+	setPanic := func(_ interface{}, err *PanicError, _ chan<- interface{}) {
+		mu.Lock()
+		if panicErr == nil {
+			panicErr = err
+		}
+		mu.Unlock()
+	}
+	workers := registerWorkers(itemOut, setPanic)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				setPanic(dataRaw, toPanicError(r), itemOut)
+			}
+			workers.wait(itemOut)
+			close(itemOut)
+		}()
+		j(itemIn, itemOut)
+	}()
+
+	output := make([]interface{}, 0, 1)
+	for value := range itemOut {
+		output = append(output, value)
+		forward(value)
+	}
+	if panicErr != nil {
+		panic(panicErr)
+	}
+	return output
+}
+
+// runStage - runs job converting its panic to error
+func runStage(j job, in, out chan interface{}) (panicErr *PanicError) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	j(in, out)
+	return nil
+}
+
+// ExecutePipelineWith - ExecutePipeline with panic isolation and configurable buffers,
+// returned error is the *StageError of the first failed stage
+func ExecutePipelineWith(opts PipelineOptions, jobs ...job) error {
+	if opts.PanicPolicy == PanicDeadLetter && opts.DeadLetters == nil {
+		return fmt.Errorf("dead letter policy needs DeadLetters channel")
+	}
+
+	mu := &sync.Mutex{}
+	var firstErr error
+	wg := &sync.WaitGroup{}
+	inCh := make(chan interface{}, MaxInputDataLen)
+	// nothing is sent to the first stage
+	close(inCh)
+	names := make([]string, 0, len(jobs))
+	for i := range jobs {
+		name := fmt.Sprintf("stage %d", i)
+		if i < len(opts.StageNames) && opts.StageNames[i] != "" {
+			name = opts.StageNames[i]
+		}
+		names = append(names, name)
+	}
+	for i, buf := range opts.Buffers {
+		if i < len(jobs) && buf.Size < 1 {
+			return fmt.Errorf("%s: buffer size must be > 0, got %d", names[i], buf.Size)
+		}
+	}
+	if opts.Metrics != nil {
+		opts.Metrics.reset(names)
+	}
+
+	for i, j := range jobs {
+		buf := StageBuffer{Size: MaxInputDataLen}
+		if i < len(opts.Buffers) {
+			buf = opts.Buffers[i]
+		}
+		outCh := make(chan interface{}, buf.Size)
+		stageOut := outCh
+		if buf.DropOldest || opts.Metrics != nil {
+			stageOut = make(chan interface{})
+			wg.Add(1)
+			go func(from <-chan interface{}, to chan interface{}, buf StageBuffer, idx int) {
+				defer wg.Done()
+				pump(from, to, buf, opts.Metrics, idx)
+			}(stageOut, outCh, buf, i)
+		}
+
+		wg.Add(1)
+		go func(wG *sync.WaitGroup, j job, name string, in chan interface{}, out chan interface{}) {
+			defer wG.Done()
+
+			si := newStageInput(in, out, opts.Tracer, name)
+			jobOut := si.out
+			workers := registerWorkers(jobOut, func(data interface{}, panicErr *PanicError, out chan<- interface{}) {
+				stageErr := &StageError{Stage: name, Data: data, Err: panicErr}
+				switch opts.PanicPolicy {
+				case PanicAbort:
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = stageErr
+					}
+					mu.Unlock()
+					si.abortInput()
+				case PanicDeadLetter:
+					opts.DeadLetters <- stageErr
+				default:
+					out <- stageErr
+				}
+			})
+			workers.spans = si.spans
+			defer func() {
+				// workers of a panicked stage may still be sending
+				workers.wait(jobOut)
+				si.closeOutput()
+				close(out)
+			}()
+			defer si.close()
+			panicErr := runStage(j, si.ch, jobOut)
+			if panicErr == nil {
+				return
+			}
+			item, seq, _ := si.state()
+			stageErr := &StageError{Stage: name, Data: item, Err: panicErr}
+			if seq == 0 {
+				stageErr.Data = nil
+			}
+
+			// restarted job would lose what it collected so far (e.g. CombineResults),
+			// so the stage fails whatever the policy is
+			mu.Lock()
+			if firstErr == nil {
+				firstErr = stageErr
+			}
+			mu.Unlock()
+			switch opts.PanicPolicy {
+			case PanicDeadLetter:
+				opts.DeadLetters <- stageErr
+			case PanicSkipItem:
+				jobOut <- stageErr
+			}
+			si.drain()
+		}(wg, j, names[i], inCh, stageOut)
+		inCh = outCh
+	}
+	wg.Wait()
+	return firstErr
+}
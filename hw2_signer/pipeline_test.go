@@ -0,0 +1,341 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"hw2_signer/pipetest"
+)
+
+func numbersJob(n int) job {
+	return job(func(in, out chan interface{}) {
+		for i := 0; i < n; i++ {
+			out <- i
+		}
+	})
+}
+
+// panicOnJob - panics on item 3, passes the rest
+var panicOnJob = job(func(in, out chan interface{}) {
+	for dataRaw := range in {
+		if dataRaw.(int) == 3 {
+			panic("bad item")
+		}
+		out <- dataRaw
+	}
+})
+
+func executeWithDeadline(t *testing.T, opts PipelineOptions, jobs ...job) error {
+	var err error
+	pipetest.Run(t, 5*time.Second, func() {
+		err = ExecutePipelineWith(opts, jobs...)
+	})
+	return err
+}
+
+func TestPipelinePanicAbort(t *testing.T) {
+	var received uint32
+	err := executeWithDeadline(t, PipelineOptions{StageNames: []string{"source", "checker"}},
+		numbersJob(10),
+		panicOnJob,
+		job(func(in, out chan interface{}) {
+			for range in {
+				atomic.AddUint32(&received, 1)
+			}
+		}),
+	)
+
+	stageErr, ok := err.(*StageError)
+	if !ok {
+		t.Fatalf("expected *StageError, got %v", err)
+	}
+	if stageErr.Stage != "checker" || stageErr.Data != 3 {
+		t.Errorf("unexpected error attribution: %s", stageErr)
+	}
+	if panicErr, ok := stageErr.Err.(*PanicError); !ok || panicErr.Value != "bad item" || len(panicErr.Stack) == 0 {
+		t.Errorf("unexpected panic error: %v", stageErr.Err)
+	}
+	if received != 3 {
+		t.Errorf("expected 3 items before abort, got %d", received)
+	}
+}
+
+func TestPipelinePanicSkipItem(t *testing.T) {
+	var received, stageErrs uint32
+	err := executeWithDeadline(t, PipelineOptions{PanicPolicy: PanicSkipItem},
+		numbersJob(10),
+		panicOnJob,
+		job(func(in, out chan interface{}) {
+			for dataRaw := range in {
+				if stageErr, ok := dataRaw.(*StageError); ok {
+					if stageErr.Stage != "stage 1" || stageErr.Data != 3 {
+						t.Errorf("unexpected error attribution: %s", stageErr)
+					}
+					stageErrs++
+					continue
+				}
+				received++
+			}
+		}),
+	)
+	// panic of the job itself fails the stage, its error goes downstream as well
+	if stageErr, ok := err.(*StageError); !ok || stageErr.Data != 3 {
+		t.Errorf("expected *StageError of item 3, got %v", err)
+	}
+	if received != 3 || stageErrs != 1 {
+		t.Errorf("expected 3 items and 1 error, got %d and %d", received, stageErrs)
+	}
+}
+
+func TestPipelinePanicDeadLetter(t *testing.T) {
+	deadLetters := make(chan *StageError, 10)
+	var received uint32
+	err := executeWithDeadline(t, PipelineOptions{PanicPolicy: PanicDeadLetter, DeadLetters: deadLetters},
+		numbersJob(10),
+		panicOnJob,
+		job(func(in, out chan interface{}) {
+			for range in {
+				received++
+			}
+		}),
+	)
+	close(deadLetters)
+	if stageErr, ok := err.(*StageError); !ok || stageErr.Data != 3 {
+		t.Errorf("expected *StageError of item 3, got %v", err)
+	}
+	if received != 3 {
+		t.Errorf("expected 3 items, got %d", received)
+	}
+	letters := 0
+	for stageErr := range deadLetters {
+		letters++
+		if stageErr.Data != 3 {
+			t.Errorf("unexpected dead letter: %s", stageErr)
+		}
+	}
+	if letters != 1 {
+		t.Errorf("expected 1 dead letter, got %d", letters)
+	}
+
+	if err := ExecutePipelineWith(PipelineOptions{PanicPolicy: PanicDeadLetter}); err == nil {
+		t.Errorf("expected error for missing dead letter channel")
+	}
+}
+
+func TestPipelinePanicStatefulStage(t *testing.T) {
+	for _, policy := range []PanicPolicy{PanicAbort, PanicSkipItem, PanicDeadLetter} {
+		deadLetters := make(chan *StageError, 10)
+		results := []string{}
+		err := executeWithDeadline(t, PipelineOptions{PanicPolicy: policy, DeadLetters: deadLetters},
+			numbersJob(10),
+			job(func(in, out chan interface{}) {
+				// CombineResults of items checked so far, panics on item 3
+				checked := make(chan interface{}, MaxInputDataLen)
+				for dataRaw := range in {
+					if dataRaw.(int) == 3 {
+						panic("bad item")
+					}
+					checked <- strconv.Itoa(dataRaw.(int))
+				}
+				close(checked)
+				CombineResults(checked, out)
+			}),
+			job(func(in, out chan interface{}) {
+				for dataRaw := range in {
+					if result, ok := dataRaw.(string); ok {
+						results = append(results, result)
+					}
+				}
+			}),
+		)
+		close(deadLetters)
+		// restarted job would combine the items after the panicked one only
+		if stageErr, ok := err.(*StageError); !ok || stageErr.Data != 3 {
+			t.Errorf("policy %d: expected *StageError of item 3, got %v", policy, err)
+		}
+		if len(results) != 0 {
+			t.Errorf("policy %d: expected no combined result, got %v", policy, results)
+		}
+	}
+}
+
+func TestPipelinePanicWithoutInput(t *testing.T) {
+	err := executeWithDeadline(t, PipelineOptions{PanicPolicy: PanicSkipItem},
+		numbersJob(10),
+		job(func(in, out chan interface{}) {
+			panic("broken stage")
+		}),
+	)
+	stageErr, ok := err.(*StageError)
+	if !ok || stageErr.Data != nil {
+		t.Errorf("stage which panics by itself must abort, got %v", err)
+	}
+}
+
+func TestPipelineStagesFinish(t *testing.T) {
+	// pipetest.Run checks that feeding of the stage which is over stops as well
+	executeWithDeadline(t, PipelineOptions{},
+		job(func(in, out chan interface{}) {
+			// nothing is sent to the first stage, its input is over at once
+			for range in {
+			}
+			for i := 0; i < 10; i++ {
+				out <- i
+			}
+		}),
+		job(func(in, out chan interface{}) {
+			// stage does not read its input
+		}),
+	)
+}
+
+func TestSignerPanicIsStageError(t *testing.T) {
+	crc32 := func(data string) (string, error) {
+		if data == "1" {
+			panic("signer is broken")
+		}
+		return data, nil
+	}
+	var stageErr *StageError
+	executeWithDeadline(t, PipelineOptions{},
+		numbersJob(2),
+		SingleHashWith(NewSHA256Signer(), SignerErrFunc(crc32)),
+		job(func(in, out chan interface{}) {
+			for dataRaw := range in {
+				if err, ok := dataRaw.(*StageError); ok {
+					stageErr = err
+				}
+			}
+		}),
+	)
+	if stageErr == nil || stageErr.Data != "1" {
+		t.Fatalf("expected stage error for item 1, got %v", stageErr)
+	}
+	if _, ok := stageErr.Err.(*PanicError); !ok {
+		t.Errorf("expected *PanicError, got %v", stageErr.Err)
+	}
+}
+
+// workerPanicJob - like panicOnJob, but items are processed by goroutines of the stage
+var workerPanicJob = job(func(in, out chan interface{}) {
+	wg := &sync.WaitGroup{}
+	for dataRaw := range in {
+		wg.Add(1)
+		dataRaw := dataRaw
+		goWorker(out, dataRaw, func(out chan<- interface{}) {
+			defer wg.Done()
+			if dataRaw.(int) == 3 {
+				panic("bad item")
+			}
+			out <- dataRaw
+		})
+	}
+	wg.Wait()
+})
+
+func TestPipelineWorkerPanic(t *testing.T) {
+	for _, policy := range []PanicPolicy{PanicAbort, PanicSkipItem, PanicDeadLetter} {
+		deadLetters := make(chan *StageError, 10)
+		var received, stageErrs uint32
+		err := executeWithDeadline(t, PipelineOptions{PanicPolicy: policy, DeadLetters: deadLetters},
+			numbersJob(1000),
+			workerPanicJob,
+			job(func(in, out chan interface{}) {
+				for dataRaw := range in {
+					if stageErr, ok := dataRaw.(*StageError); ok {
+						if stageErr.Data != 3 {
+							t.Errorf("unexpected error attribution: %s", stageErr)
+						}
+						stageErrs++
+						continue
+					}
+					received++
+				}
+			}),
+		)
+		close(deadLetters)
+
+		switch policy {
+		case PanicAbort:
+			stageErr, ok := err.(*StageError)
+			if !ok || stageErr.Data != 3 {
+				t.Fatalf("expected *StageError of item 3, got %v", err)
+			}
+		case PanicSkipItem:
+			if err != nil || received != 999 || stageErrs != 1 {
+				t.Errorf("expected 999 items and 1 error, got %d, %d, %v", received, stageErrs, err)
+			}
+		case PanicDeadLetter:
+			if err != nil || received != 999 || len(deadLetters) != 1 {
+				t.Errorf("expected 999 items and 1 dead letter, got %d, %d, %v", received, len(deadLetters), err)
+			}
+		}
+	}
+}
+
+func TestPipelineStagePanicWaitsForWorkers(t *testing.T) {
+	var received uint32
+	release := make(chan struct{})
+	executeWithDeadline(t, PipelineOptions{},
+		numbersJob(1),
+		job(func(in, out chan interface{}) {
+			for dataRaw := range in {
+				dataRaw := dataRaw
+				goWorker(out, dataRaw, func(out chan<- interface{}) {
+					<-release
+					out <- dataRaw
+				})
+			}
+			close(release)
+			panic("stage is broken")
+		}),
+		job(func(in, out chan interface{}) {
+			for range in {
+				atomic.AddUint32(&received, 1)
+			}
+		}),
+	)
+	if received != 1 {
+		t.Errorf("output of workers must not be lost, got %d items", received)
+	}
+}
+
+func TestCheckpointWorkerPanic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signer_checkpoint")
+	if err != nil {
+		t.Fatalf("cant create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	cp, err := OpenCheckpoint(filepath.Join(dir, "run.checkpoint"), "test")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer cp.Close()
+
+	var received, stageErrs uint32
+	err = executeWithDeadline(t, PipelineOptions{PanicPolicy: PanicSkipItem},
+		numbersJob(10),
+		cp.Stage("worker", workerPanicJob),
+		job(func(in, out chan interface{}) {
+			for dataRaw := range in {
+				if _, ok := dataRaw.(*StageError); ok {
+					stageErrs++
+					continue
+				}
+				received++
+			}
+		}),
+	)
+	if err != nil || received != 9 || stageErrs != 1 {
+		t.Errorf("expected 9 items and 1 error, got %d, %d, %v", received, stageErrs, err)
+	}
+	if cp.Len() != 9 {
+		t.Errorf("panicked item must not be checkpointed, got %d items", cp.Len())
+	}
+}
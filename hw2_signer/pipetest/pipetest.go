@@ -0,0 +1,140 @@
+// Package pipetest - helpers for pipeline tests: deadline for a pipeline run,
+// stacks of blocked goroutines on timeout and goroutine leak detection
+package pipetest
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TB - part of testing.TB used by Run
+type TB interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+	Fatalf(format string, args ...interface{})
+}
+
+// Goroutine - one goroutine of runtime.Stack dump
+type Goroutine struct {
+	ID    int
+	State string
+	Stack string
+}
+
+// Blocked - goroutine waits on channel, select, mutex or cond
+func (g Goroutine) Blocked() bool {
+	for _, state := range []string{"chan send", "chan receive", "select", "semacquire", "sync.Mutex.Lock", "sync.RWMutex", "sync.Cond.Wait", "sync.WaitGroup.Wait"} {
+		if strings.HasPrefix(g.State, state) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g Goroutine) String() string {
+	return g.Stack
+}
+
+// Goroutines - snapshot of all goroutines
+func Goroutines() []Goroutine {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	goroutines := []Goroutine{}
+	for _, block := range bytes.Split(buf, []byte("\n\n")) {
+		// goroutine 18 [chan receive, 2 minutes]:
+		header := string(block)
+		if idx := strings.IndexByte(header, '\n'); idx >= 0 {
+			header = header[:idx]
+		}
+		if !strings.HasPrefix(header, "goroutine ") {
+			continue
+		}
+		fields := strings.SplitN(strings.TrimPrefix(header, "goroutine "), " ", 2)
+		id, err := strconv.Atoi(fields[0])
+		if err != nil || len(fields) != 2 {
+			continue
+		}
+		state := strings.TrimSuffix(strings.TrimPrefix(fields[1], "["), "]:")
+		if idx := strings.IndexByte(state, ','); idx >= 0 {
+			state = state[:idx]
+		}
+		goroutines = append(goroutines, Goroutine{ID: id, State: state, Stack: string(block)})
+	}
+	return goroutines
+}
+
+// Started - goroutines of now which are not in before
+func Started(before, now []Goroutine) []Goroutine {
+	known := make(map[int]bool, len(before))
+	for _, g := range before {
+		known[g.ID] = true
+	}
+	started := []Goroutine{}
+	for _, g := range now {
+		if !known[g.ID] {
+			started = append(started, g)
+		}
+	}
+	return started
+}
+
+// Leaked - goroutines started after before snapshot which do not finish within grace
+func Leaked(before []Goroutine, grace time.Duration) []Goroutine {
+	deadline := time.Now().Add(grace)
+	for {
+		started := Started(before, Goroutines())
+		if len(started) == 0 || time.Now().After(deadline) {
+			return started
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func dump(goroutines []Goroutine) string {
+	stacks := make([]string, 0, len(goroutines))
+	for _, g := range goroutines {
+		stacks = append(stacks, g.Stack)
+	}
+	return strings.Join(stacks, "\n\n")
+}
+
+// Run - runs f (e.g. ExecutePipeline call) under deadline. Test fails with stacks
+// of blocked goroutines if f does not finish in time, or with stacks of leaked
+// goroutines if f leaves anything running for longer than a second after it returns.
+func Run(t TB, timeout time.Duration, f func()) {
+	t.Helper()
+	before := Goroutines()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		blocked := []Goroutine{}
+		for _, g := range Started(before, Goroutines()) {
+			if g.Blocked() {
+				blocked = append(blocked, g)
+			}
+		}
+		t.Fatalf("pipeline did not finish in %s, %d goroutines blocked:\n\n%s", timeout, len(blocked), dump(blocked))
+		return
+	}
+
+	if leaked := Leaked(before, time.Second); len(leaked) != 0 {
+		t.Errorf("%d goroutines leaked:\n\n%s", len(leaked), dump(leaked))
+	}
+}
@@ -0,0 +1,86 @@
+package pipetest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+type recorder struct {
+	errors []string
+	fatal  bool
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Fatalf(format string, args ...interface{}) {
+	r.fatal = true
+	r.Errorf(format, args...)
+}
+
+func blockedForever(ch chan int) {
+	ch <- 1
+}
+
+func TestRunOK(t *testing.T) {
+	r := &recorder{}
+	Run(r, time.Second, func() {
+		ch := make(chan int)
+		go func() { ch <- 1 }()
+		<-ch
+	})
+	if len(r.errors) != 0 {
+		t.Errorf("unexpected errors: %v", r.errors)
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	r := &recorder{}
+	stuck := make(chan int)
+	defer close(stuck)
+	Run(r, 50*time.Millisecond, func() {
+		<-stuck
+	})
+	if !r.fatal || len(r.errors) != 1 {
+		t.Fatalf("expected fatal error, got %v", r.errors)
+	}
+	if !strings.Contains(r.errors[0], "1 goroutines blocked") || !strings.Contains(r.errors[0], "[chan receive") {
+		t.Errorf("expected stack of blocked goroutine, got %s", r.errors[0])
+	}
+}
+
+func TestRunLeak(t *testing.T) {
+	r := &recorder{}
+	ch := make(chan int)
+	Run(r, time.Second, func() {
+		go blockedForever(ch)
+	})
+	<-ch
+	if r.fatal || len(r.errors) != 1 {
+		t.Fatalf("expected leak error, got %v", r.errors)
+	}
+	if !strings.Contains(r.errors[0], "1 goroutines leaked") || !strings.Contains(r.errors[0], "blockedForever") {
+		t.Errorf("expected stack of leaked goroutine, got %s", r.errors[0])
+	}
+}
+
+func TestGoroutines(t *testing.T) {
+	before := Goroutines()
+	ch := make(chan int)
+	go blockedForever(ch)
+	defer func() { <-ch }()
+
+	time.Sleep(10 * time.Millisecond)
+	started := Started(before, Goroutines())
+	if len(started) != 1 {
+		t.Fatalf("expected 1 new goroutine, got %d", len(started))
+	}
+	if started[0].State != "chan send" || !started[0].Blocked() {
+		t.Errorf("unexpected state %q", started[0].State)
+	}
+}
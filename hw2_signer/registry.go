@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// StageConfig - one stage of a pipeline config, signers are looked up by name
+type StageConfig struct {
+	Name    string   `json:"name"`
+	Signers []string `json:"signers"`
+}
+
+// PipelineConfig - pipeline described as a list of registered stages, e.g.
+// {"stages": [{"name": "SingleHash", "signers": ["sha256", "xxhash"]}, {"name": "CombineResults"}]}
+type PipelineConfig struct {
+	Stages []StageConfig `json:"stages"`
+}
+
+type stageFactory struct {
+	defaultSigners []string
+	// perItem stages can be checkpointed
+	perItem bool
+	build   func(signers []Signer) job
+}
+
+// BuildOptions - settings shared by all stages of a pipeline
+type BuildOptions struct {
+	// Key - secret for keyed signers
+	Key []byte
+	// Concurrency > 0 limits parallel calls of every signer
+	Concurrency int
+	// Checkpoint makes per-item stages resumable if set
+	Checkpoint *Checkpoint
+	// Trace - signer calls of per-item stages are recorded in the spans of their
+	// items, pipeline must be run with a Tracer to have spans
+	Trace bool
+}
+
+var stageRegistry = map[string]stageFactory{
+	"SingleHash": {
+		defaultSigners: []string{"md5", "crc32"},
+		perItem:        true,
+		build:          func(signers []Signer) job { return SingleHashWith(signers[0], signers[1]) },
+	},
+	"MultiHash": {
+		defaultSigners: []string{"crc32"},
+		perItem:        true,
+		build:          func(signers []Signer) job { return MultiHashWith(signers[0]) },
+	},
+	"CombineResults": {
+		build: func(signers []Signer) job { return CombineResults },
+	},
+}
+
+// DefaultPipelineConfig - SingleHash -> MultiHash -> CombineResults over md5 and crc32
+func DefaultPipelineConfig() PipelineConfig {
+	return PipelineConfig{Stages: []StageConfig{
+		{Name: "SingleHash"},
+		{Name: "MultiHash"},
+		{Name: "CombineResults"},
+	}}
+}
+
+// LoadPipelineConfig - reads json config from file
+func LoadPipelineConfig(path string) (PipelineConfig, error) {
+	cfg := PipelineConfig{}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("bad pipeline config %s: %s", path, err)
+	}
+	if len(cfg.Stages) == 0 {
+		return cfg, fmt.Errorf("bad pipeline config %s: no stages", path)
+	}
+	return cfg, nil
+}
+
+// NewSignerByName - signers available to pipeline configs, key is used by keyed ones
+func NewSignerByName(name string, key []byte) (Signer, error) {
+	switch name {
+	case "md5":
+		return Exclusive(Fallible(DataSignerMd5)), nil
+	case "crc32":
+		return Fallible(DataSignerCrc32), nil
+	case "sha256":
+		return NewSHA256Signer(), nil
+	case "blake2b":
+		return NewBLAKE2bSigner(key)
+	case "xxhash":
+		return NewXXHashSigner(), nil
+	case "hmac-sha256":
+		if len(key) == 0 {
+			return nil, fmt.Errorf("signer %s needs a key", name)
+		}
+		return NewHMACSigner(sha256.New, key), nil
+	}
+	return nil, fmt.Errorf("unknown signer %s", name)
+}
+
+// tracedSigners - signers of a stage with their calls recorded in span
+func tracedSigners(span *Span, names []string, signers []Signer) []Signer {
+	traced := make([]Signer, 0, len(signers))
+	for i, signer := range signers {
+		traced = append(traced, span.Signer(names[i], signer))
+	}
+	return traced
+}
+
+// PipelineFingerprint - hash of everything outputs of cfg depend on: stages,
+// their signers, salt and key. Checkpoints of other settings are not reused.
+func PipelineFingerprint(cfg PipelineConfig, salt string, key []byte) string {
+	h := sha256.New()
+	for _, stage := range cfg.Stages {
+		names := stage.Signers
+		if factory, ok := stageRegistry[stage.Name]; ok && len(names) == 0 {
+			names = factory.defaultSigners
+		}
+		fmt.Fprintf(h, "%q %q\n", stage.Name, names)
+	}
+	fmt.Fprintf(h, "salt %q\nkey %q\n", salt, key)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// BuildPipeline - turns config into jobs
+func BuildPipeline(cfg PipelineConfig, opts BuildOptions) ([]job, error) {
+	jobs := make([]job, 0, len(cfg.Stages))
+	for i, stage := range cfg.Stages {
+		factory, ok := stageRegistry[stage.Name]
+		if !ok {
+			return nil, fmt.Errorf("stage %d: unknown stage %s", i, stage.Name)
+		}
+		names := stage.Signers
+		if len(names) == 0 {
+			names = factory.defaultSigners
+		}
+		if len(names) != len(factory.defaultSigners) {
+			return nil, fmt.Errorf("stage %d: %s needs %d signers, got %d",
+				i, stage.Name, len(factory.defaultSigners), len(names))
+		}
+		signers := make([]Signer, 0, len(names))
+		for _, name := range names {
+			signer, err := NewSignerByName(name, opts.Key)
+			if err != nil {
+				return nil, fmt.Errorf("stage %d: %s", i, err)
+			}
+			if opts.Concurrency > 0 {
+				signer = Throttle(signer, opts.Concurrency)
+			}
+			signers = append(signers, signer)
+		}
+		checkpointName := fmt.Sprintf("%d:%s", i, stage.Name)
+		build := func(signers []Signer) job {
+			j := factory.build(signers)
+			if opts.Checkpoint != nil && factory.perItem {
+				// stage index keeps checkpoints of equal stages apart
+				j = opts.Checkpoint.Stage(checkpointName, j)
+			}
+			return j
+		}
+		j := build(signers)
+		if opts.Trace && factory.perItem {
+			j = SpanStage(func(span *Span) job {
+				return build(tracedSigners(span, names, signers))
+			})
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, nil
+}
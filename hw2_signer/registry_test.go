@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildPipelineErrors(t *testing.T) {
+	testCases := []PipelineConfig{
+		{Stages: []StageConfig{{Name: "NoSuchStage"}}},
+		{Stages: []StageConfig{{Name: "MultiHash", Signers: []string{"nosuchsigner"}}}},
+		{Stages: []StageConfig{{Name: "MultiHash", Signers: []string{"crc32", "md5"}}}},
+		{Stages: []StageConfig{{Name: "MultiHash", Signers: []string{"hmac-sha256"}}}},
+	}
+	for caseNum, cfg := range testCases {
+		if _, err := BuildPipeline(cfg, BuildOptions{}); err == nil {
+			t.Errorf("expected error but got none, case %d", caseNum)
+		}
+	}
+}
+
+func TestRunDefaultPipeline(t *testing.T) {
+	defer func(salt string) { DataSignerSalt = salt }(DataSignerSalt)
+
+	out := new(bytes.Buffer)
+	err := run(nil, strings.NewReader("0\n\n1\n"), out)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := "29568666068035183841425683795340791879727309630931025356555_4958044192186797981418233587017209679042592862002427381542\n"
+	if out.String() != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", out.String(), expected)
+	}
+}
+
+func TestRunConfigJSON(t *testing.T) {
+	defer func(salt string) { DataSignerSalt = salt }(DataSignerSalt)
+
+	dir, err := ioutil.TempDir("", "signer_cli")
+	if err != nil {
+		t.Fatalf("cant create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	configPath := filepath.Join(dir, "pipeline.json")
+	config := `{"stages": [{"name": "SingleHash", "signers": ["hmac-sha256", "xxhash"]}, {"name": "MultiHash", "signers": ["xxhash"]}]}`
+	if err := ioutil.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatalf("cant write config: %s", err)
+	}
+	dataPath := filepath.Join(dir, "data.txt")
+	if err := ioutil.WriteFile(dataPath, []byte("abc\n"), 0644); err != nil {
+		t.Fatalf("cant write data: %s", err)
+	}
+
+	out := new(bytes.Buffer)
+	err = run([]string{"-config", configPath, "-format", "json", "-key", "secret", dataPath, "-"},
+		strings.NewReader("42\n"), out)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 results, got %q", out.String())
+	}
+	for _, line := range lines {
+		res := resultLine{}
+		if err := json.Unmarshal([]byte(line), &res); err != nil {
+			t.Errorf("bad json line %q: %s", line, err)
+		}
+		// 6 xxhash hex digests
+		if res.Error != "" || len(res.Result) != 6*16 {
+			t.Errorf("unexpected result %+v", res)
+		}
+	}
+}
+
+func TestRunBadArgs(t *testing.T) {
+	testCases := [][]string{
+		{"-format", "xml"},
+		{"-config", "./no_such_config.json"},
+		{"./no_such_input.txt"},
+	}
+	for caseNum, args := range testCases {
+		if err := run(args, strings.NewReader(""), ioutil.Discard); err == nil {
+			t.Errorf("expected error but got none, case %d", caseNum)
+		}
+	}
+}
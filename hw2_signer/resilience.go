@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+var (
+	ErrSignerTimeout = errors.New("signer timeout")
+	ErrCircuitOpen   = errors.New("signer circuit is open")
+	// errSignerPanic - what CircuitBreaker counts a panicked call as
+	errSignerPanic = errors.New("signer panicked")
+)
+
+// SignerErrFunc - signer which is allowed to fail, e.g. a remote call
+type SignerErrFunc func(data string) (string, error)
+
+// Fallible - adapts plain signer to SignerErrFunc, it never fails
+func Fallible(f SignerFunc) SignerErrFunc {
+	return func(data string) (string, error) {
+		return f(data), nil
+	}
+}
+
+type signResult struct {
+	res string
+	err error
+}
+
+// sign - runs signer in background, result channel is buffered so abandoned calls do not leak.
+// Panic of the signer is returned as *PanicError.
+func sign(f SignerErrFunc, data string) <-chan signResult {
+	result := make(chan signResult, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				result <- signResult{err: &PanicError{Value: r, Stack: debug.Stack()}}
+			}
+		}()
+		res, err := f(data)
+		result <- signResult{res: res, err: err}
+	}()
+	return result
+}
+
+// WithTimeout - fails with ErrSignerTimeout if f does not answer in time,
+// hung call itself can not be cancelled and finishes in background
+func WithTimeout(f SignerErrFunc, timeout time.Duration) SignerErrFunc {
+	return func(data string) (string, error) {
+		select {
+		case r := <-sign(f, data):
+			return r.res, r.err
+		case <-SignerClock.After(timeout):
+			return "", ErrSignerTimeout
+		}
+	}
+}
+
+// RetryPolicy - how many times and how often failed call is repeated
+type RetryPolicy struct {
+	Attempts  int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// backoff - exponential delay before retry number attempt with jitter in [d/2, d)
+func (rp RetryPolicy) backoff(attempt int) time.Duration {
+	d := rp.BaseDelay << uint(attempt)
+	if d <= 0 || (rp.MaxDelay > 0 && d > rp.MaxDelay) {
+		d = rp.MaxDelay
+	}
+	if d <= 1 {
+		return d
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)))
+}
+
+// WithRetry - repeats failed calls according to policy, open circuit is not retried
+func WithRetry(f SignerErrFunc, policy RetryPolicy) SignerErrFunc {
+	return func(data string) (string, error) {
+		var err error
+		for attempt := 0; attempt < policy.Attempts || attempt == 0; attempt++ {
+			if attempt != 0 {
+				SignerClock.Sleep(policy.backoff(attempt - 1))
+			}
+			var res string
+			res, err = f(data)
+			if err == nil {
+				return res, nil
+			}
+			if err == ErrCircuitOpen {
+				break
+			}
+		}
+		return "", err
+	}
+}
+
+const (
+	circuitClosed = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// CircuitBreaker - stops calling signer after threshold consecutive failures,
+// one trial call is let through after cooldown
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	state     int
+	openedAt  time.Time
+}
+
+// NewCircuitBreaker -
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	if threshold <= 0 {
+		threshold = 1
+	}
+	return &CircuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// Open - true while calls are rejected
+func (cb *CircuitBreaker) Open() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state == circuitOpen && SignerClock.Now().Sub(cb.openedAt) < cb.cooldown
+}
+
+func (cb *CircuitBreaker) allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	switch cb.state {
+	case circuitOpen:
+		if SignerClock.Now().Sub(cb.openedAt) < cb.cooldown {
+			return false
+		}
+		cb.state = circuitHalfOpen
+		return true
+	case circuitHalfOpen:
+		// trial call is already in flight
+		return false
+	}
+	return true
+}
+
+func (cb *CircuitBreaker) report(err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if err == nil {
+		cb.failures = 0
+		cb.state = circuitClosed
+		return
+	}
+	cb.failures++
+	if cb.state == circuitHalfOpen || cb.failures >= cb.threshold {
+		cb.state = circuitOpen
+		cb.openedAt = SignerClock.Now()
+	}
+}
+
+// Wrap - returns signer guarded by the breaker
+func (cb *CircuitBreaker) Wrap(f SignerErrFunc) SignerErrFunc {
+	return func(data string) (string, error) {
+		if !cb.allow() {
+			return "", ErrCircuitOpen
+		}
+		reported := false
+		defer func() {
+			if !reported {
+				// panic is a failure too, or a trial call would leave the breaker half-open for good
+				cb.report(errSignerPanic)
+			}
+		}()
+		res, err := f(data)
+		reported = true
+		cb.report(err)
+		return res, err
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"hw2_signer/pipetest"
+)
+
+var errRemote = errors.New("remote failure")
+
+func flakySigner(calls *uint32, failFirst uint32) SignerErrFunc {
+	return func(data string) (string, error) {
+		if atomic.AddUint32(calls, 1) <= failFirst {
+			return "", errRemote
+		}
+		return "h(" + data + ")", nil
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	hung := func(data string) (string, error) {
+		time.Sleep(time.Second)
+		return data, nil
+	}
+	start := time.Now()
+	_, err := WithTimeout(hung, 20*time.Millisecond)("1")
+	if err != ErrSignerTimeout {
+		t.Errorf("expected timeout, got %v", err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("timeout was not applied")
+	}
+
+	res, err := WithTimeout(Fallible(func(data string) string { return data }), time.Second)("1")
+	if err != nil || res != "1" {
+		t.Errorf("unexpected result %q, %v", res, err)
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	var calls uint32
+	policy := RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+	res, err := WithRetry(flakySigner(&calls, 2), policy)("1")
+	if err != nil || res != "h(1)" {
+		t.Errorf("unexpected result %q, %v", res, err)
+	}
+	if calls != 3 {
+		t.Errorf("expected 3 calls, got %d", calls)
+	}
+
+	calls = 0
+	_, err = WithRetry(flakySigner(&calls, 10), policy)("1")
+	if err != errRemote {
+		t.Errorf("expected remote error, got %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("expected 3 calls, got %d", calls)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 10 * time.Millisecond, MaxDelay: 40 * time.Millisecond}
+	for attempt, max := range []time.Duration{10, 20, 40, 40, 40} {
+		max *= time.Millisecond
+		d := policy.backoff(attempt)
+		if d < max/2 || d >= max {
+			t.Errorf("attempt %d: delay %s out of [%s, %s)", attempt, d, max/2, max)
+		}
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var calls uint32
+	cb := NewCircuitBreaker(2, 50*time.Millisecond)
+	signer := cb.Wrap(flakySigner(&calls, 3))
+
+	for i := 0; i < 2; i++ {
+		if _, err := signer("1"); err != errRemote {
+			t.Errorf("call %d: expected remote error, got %v", i, err)
+		}
+	}
+	if !cb.Open() {
+		t.Fatalf("breaker should be open after 2 failures")
+	}
+	if _, err := signer("1"); err != ErrCircuitOpen {
+		t.Errorf("expected open circuit, got %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("open breaker must not call signer, calls = %d", calls)
+	}
+
+	// trial call fails and opens circuit again
+	time.Sleep(60 * time.Millisecond)
+	if _, err := signer("1"); err != errRemote {
+		t.Errorf("expected trial call, got %v", err)
+	}
+	if !cb.Open() {
+		t.Errorf("failed trial call should open breaker")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if res, err := signer("1"); err != nil || res != "h(1)" {
+		t.Errorf("unexpected result %q, %v", res, err)
+	}
+	if cb.Open() {
+		t.Errorf("successful trial call should close breaker")
+	}
+}
+
+func TestCircuitBreakerPanic(t *testing.T) {
+	fc, restore := useFakeClock()
+	defer restore()
+
+	cb := NewCircuitBreaker(1, time.Second)
+	panics := true
+	signer := cb.Wrap(func(data string) (string, error) {
+		if panics {
+			panic("signer is broken")
+		}
+		return "h(" + data + ")", nil
+	})
+	call := func() (res string, err error, panicked bool) {
+		defer func() {
+			panicked = recover() != nil
+		}()
+		res, err = signer("1")
+		return res, err, false
+	}
+
+	if _, _, panicked := call(); !panicked || !cb.Open() {
+		t.Fatalf("panic must go on and open the breaker")
+	}
+	// trial call panics too, breaker opens again instead of staying half-open
+	fc.Advance(time.Second)
+	if _, _, panicked := call(); !panicked || !cb.Open() {
+		t.Fatalf("panicked trial call should open breaker")
+	}
+	fc.Advance(time.Second)
+	panics = false
+	if res, err, _ := call(); err != nil || res != "h(1)" || cb.Open() {
+		t.Errorf("unexpected result %q, %v, open %v", res, err, cb.Open())
+	}
+}
+
+func TestPipelineStageErrors(t *testing.T) {
+	crc32 := func(data string) (string, error) {
+		if data == "0" {
+			return "", errRemote
+		}
+		return "c" + data, nil
+	}
+	md5 := func(data string) (string, error) {
+		return "m" + data, nil
+	}
+
+	var result string
+	var stageErrs []*StageError
+	pipetest.Run(t, 5*time.Second, func() {
+		ExecutePipeline(
+			job(func(in, out chan interface{}) {
+				out <- 0
+				out <- 1
+			}),
+			SingleHashWith(SignerErrFunc(md5), SignerErrFunc(crc32)),
+			MultiHashWith(SignerErrFunc(crc32)),
+			job(CombineResults),
+			job(func(in, out chan interface{}) {
+				for dataRaw := range in {
+					switch data := dataRaw.(type) {
+					case *StageError:
+						stageErrs = append(stageErrs, data)
+					case string:
+						result = data
+					}
+				}
+			}),
+		)
+	})
+
+	if len(stageErrs) != 1 || stageErrs[0].Stage != "SingleHash" || stageErrs[0].Err != errRemote {
+		t.Errorf("unexpected stage errors: %v", stageErrs)
+	}
+	if result != "c0c1~cm1c1c1~cm1c2c1~cm1c3c1~cm1c4c1~cm1c5c1~cm1" {
+		t.Errorf("unexpected result: %s", result)
+	}
+}
@@ -3,92 +3,145 @@ package main
 import (
 	"bytes"
 	"fmt"
-	"sort"
 	"strconv"
 	"sync"
 )
 
+// StageError - failure of a single item inside a pipeline stage,
+// it is sent downstream instead of the result and passed through by the following stages
+type StageError struct {
+	Stage string
+	Data  interface{}
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	return fmt.Sprintf("%s: item %v: %s", e.Stage, e.Data, e.Err)
+}
+
 func SingleHash(in, out chan interface{}) {
-	mu := &sync.Mutex{}
-	wg := &sync.WaitGroup{}
-	for dataRaw := range in {
-		dataInt, ok := dataRaw.(int)
-		if !ok {
-			fmt.Println("SH: cant convert result data to int")
-		} else {
-			dataStr := strconv.Itoa(dataInt)
-			wg.Add(1)
-
-			go func(inStr string, mU *sync.Mutex, wG *sync.WaitGroup, out chan<- interface{}) {
-				defer wG.Done()
-
-				resultBare := make(chan string, 1)
-				go func(inStr string, out chan<- string) {
-					out <- DataSignerCrc32(inStr)
-				}(dataStr, resultBare)
-
-				mu.Lock()
-				dataStrMd5 := DataSignerMd5(dataStr)
-				mu.Unlock()
-
-				resultMd5 := make(chan string, 1)
-				go func(inStr string, out chan<- string) {
-					out <- DataSignerCrc32(inStr)
-				}(dataStrMd5, resultMd5)
-
-				bareStr := <-resultBare
-				md5Str := <-resultMd5
-				out <- bareStr + "~" + md5Str
-			}(dataStr, mu, wg, out)
+	SingleHashWith(Exclusive(Fallible(DataSignerMd5)), Fallible(DataSignerCrc32))(in, out)
+}
+
+// SingleHashWith - SingleHash over arbitrary signers, accepts ints and strings,
+// result is checksum(data)+"~"+checksum(digest(data))
+func SingleHashWith(digest, checksum Signer) job {
+	return func(in, out chan interface{}) {
+		wg := &sync.WaitGroup{}
+		for dataRaw := range in {
+			if err, ok := dataRaw.(error); ok {
+				out <- err
+				continue
+			}
+			dataStr, ok := dataRaw.(string)
+			if dataInt, isInt := dataRaw.(int); isInt {
+				dataStr, ok = strconv.Itoa(dataInt), true
+			}
+			if !ok {
+				fmt.Println("SH: cant convert result data to int or string")
+			} else {
+				wg.Add(1)
+
+				inStr := dataStr
+				goWorker(out, inStr, func(out chan<- interface{}) {
+					defer wg.Done()
+
+					resultBare := sign(checksum.Sign, inStr)
+
+					digestRes := <-sign(digest.Sign, inStr)
+					if digestRes.err != nil {
+						out <- &StageError{Stage: "SingleHash", Data: inStr, Err: digestRes.err}
+						return
+					}
+
+					resultMd5 := sign(checksum.Sign, digestRes.res)
+
+					bare := <-resultBare
+					md5Res := <-resultMd5
+					if bare.err != nil {
+						out <- &StageError{Stage: "SingleHash", Data: inStr, Err: bare.err}
+						return
+					}
+					if md5Res.err != nil {
+						out <- &StageError{Stage: "SingleHash", Data: inStr, Err: md5Res.err}
+						return
+					}
+					out <- bare.res + "~" + md5Res.res
+				})
+			}
 		}
+		wg.Wait()
 	}
-	wg.Wait()
 }
 
 func MultiHash(in, out chan interface{}) {
-	type retPair struct {
-		idx int
-		res string
-	}
+	MultiHashWith(Fallible(DataSignerCrc32))(in, out)
+}
 
-	wg := &sync.WaitGroup{}
-	for dataRaw := range in {
-		dataStr, ok := dataRaw.(string)
-		if !ok {
-			fmt.Println("MH: cant convert result data to string")
-		} else {
-			wg.Add(1)
-
-			go func(inStr string, wG *sync.WaitGroup, out chan<- interface{}) {
-				defer wG.Done()
-				result := make(chan retPair, 6)
-				for i := 0; i < 6; i++ {
-					go func(inStr string, i int, out chan<- retPair) {
-						out <- retPair{idx: i, res: DataSignerCrc32(strconv.Itoa(i) + inStr)}
-					}(dataStr, i, result)
-				}
-
-				var resultArr [6]string
-				for i := 0; i < 6; i++ {
-					rp := <-result
-					resultArr[rp.idx] = rp.res
-				}
-
-				var buffer bytes.Buffer
-				for i := 0; i < 6; i++ {
-					buffer.WriteString(resultArr[i])
-				}
-
-				out <- buffer.String()
-			}(dataStr, wg, out)
+// MultiHashWith - MultiHash over arbitrary signer
+func MultiHashWith(checksum Signer) job {
+	return func(in, out chan interface{}) {
+		type retPair struct {
+			idx int
+			res signResult
 		}
+
+		wg := &sync.WaitGroup{}
+		for dataRaw := range in {
+			if err, ok := dataRaw.(error); ok {
+				out <- err
+				continue
+			}
+			dataStr, ok := dataRaw.(string)
+			if !ok {
+				fmt.Println("MH: cant convert result data to string")
+			} else {
+				wg.Add(1)
+
+				inStr := dataStr
+				goWorker(out, inStr, func(out chan<- interface{}) {
+					defer wg.Done()
+					result := make(chan retPair, 6)
+					for i := 0; i < 6; i++ {
+						go func(inStr string, i int, out chan<- retPair) {
+							out <- retPair{idx: i, res: <-sign(checksum.Sign, strconv.Itoa(i)+inStr)}
+						}(inStr, i, result)
+					}
+
+					var resultArr [6]string
+					var err error
+					for i := 0; i < 6; i++ {
+						rp := <-result
+						if rp.res.err != nil && err == nil {
+							err = rp.res.err
+						}
+						resultArr[rp.idx] = rp.res.res
+					}
+					if err != nil {
+						out <- &StageError{Stage: "MultiHash", Data: inStr, Err: err}
+						return
+					}
+
+					var buffer bytes.Buffer
+					for i := 0; i < 6; i++ {
+						buffer.WriteString(resultArr[i])
+					}
+
+					out <- buffer.String()
+				})
+			}
+		}
+		wg.Wait()
 	}
-	wg.Wait()
 }
 
 func CombineResults(in, out chan interface{}) {
 	finalRes := make([]string, 0, MaxInputDataLen)
 	for dataRaw := range in {
+		if err, ok := dataRaw.(error); ok {
+			out <- err
+			continue
+		}
 		dataStr, ok := dataRaw.(string)
 		if !ok {
 			fmt.Println("CR: cant convert result data to string")
@@ -96,30 +149,12 @@ func CombineResults(in, out chan interface{}) {
 
 		finalRes = append(finalRes, dataStr)
 	}
-	sort.Strings(finalRes)
-	var buffer bytes.Buffer
-	for i := 0; i < len(finalRes); i++ {
-		if i != 0 {
-			buffer.WriteString("_")
-		}
-		buffer.WriteString(finalRes[i])
-	}
 
-	out <- buffer.String()
+	out <- combine(finalRes)
 }
 
 func ExecutePipeline(jobs ...job) {
-	wg := &sync.WaitGroup{}
-	inCh := make(chan interface{}, MaxInputDataLen)
-	for _, j := range jobs {
-		outCh := make(chan interface{}, MaxInputDataLen)
-		wg.Add(1)
-		go func(wG *sync.WaitGroup, j job, in chan interface{}, out chan interface{}) {
-			defer wG.Done()
-			j(in, out)
-			close(out)
-		}(wg, j, inCh, outCh)
-		inCh = outCh
+	if err := ExecutePipelineWith(PipelineOptions{Tracer: PipelineTracer}, jobs...); err != nil {
+		fmt.Println("EP: pipeline aborted:", err)
 	}
-	wg.Wait()
 }
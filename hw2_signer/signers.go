@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+
+	"github.com/cespare/xxhash/v2"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Signer - hash algorithm used by pipeline stages
+type Signer interface {
+	Sign(data string) (string, error)
+}
+
+// Sign - SignerErrFunc is a Signer, so wrapped legacy functions plug in directly
+func (f SignerErrFunc) Sign(data string) (string, error) {
+	return f(data)
+}
+
+// HashSigner - Signer over any hash.Hash, result is hex encoded
+type HashSigner struct {
+	newHash func() hash.Hash
+}
+
+// Sign -
+func (hs HashSigner) Sign(data string) (string, error) {
+	h := hs.newHash()
+	if _, err := h.Write([]byte(data)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// NewSHA256Signer -
+func NewSHA256Signer() Signer {
+	return HashSigner{newHash: sha256.New}
+}
+
+// NewBLAKE2bSigner - BLAKE2b-256, non-empty key (up to 64 bytes) turns it into a MAC
+func NewBLAKE2bSigner(key []byte) (Signer, error) {
+	// check key once so Sign never fails on it
+	if _, err := blake2b.New256(key); err != nil {
+		return nil, err
+	}
+	return HashSigner{newHash: func() hash.Hash {
+		h, _ := blake2b.New256(key)
+		return h
+	}}, nil
+}
+
+// NewXXHashSigner - fast non-cryptographic 64-bit hash
+func NewXXHashSigner() Signer {
+	return HashSigner{newHash: func() hash.Hash { return xxhash.New() }}
+}
+
+// NewHMACSigner - keyed signer, newHash is e.g. sha256.New
+func NewHMACSigner(newHash func() hash.Hash, key []byte) Signer {
+	secret := make([]byte, len(key))
+	copy(secret, key)
+	return HashSigner{newHash: func() hash.Hash { return hmac.New(newHash, secret) }}
+}
+
+type throttledSigner struct {
+	sem    chan struct{}
+	signer Signer
+}
+
+func (ts throttledSigner) Sign(data string) (string, error) {
+	ts.sem <- struct{}{}
+	defer func() { <-ts.sem }()
+	return ts.signer.Sign(data)
+}
+
+// Throttle - lets at most n calls of s run at a time
+func Throttle(s Signer, n int) Signer {
+	return throttledSigner{sem: make(chan struct{}, n), signer: s}
+}
+
+// Exclusive - lets only one call of s run at a time, DataSignerMd5 overheats otherwise
+func Exclusive(s Signer) Signer {
+	return Throttle(s, 1)
+}
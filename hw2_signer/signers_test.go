@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/sha256"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSigners(t *testing.T) {
+	blake, err := NewBLAKE2bSigner(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	testCases := []struct {
+		name     string
+		signer   Signer
+		data     string
+		expected string
+	}{
+		{"sha256", NewSHA256Signer(), "abc", "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{"blake2b", blake, "abc", "bddd813c634239723171ef3fee98579b94964e3bb1cb3e427262c8c068d52319"},
+		{"xxhash", NewXXHashSigner(), "", "ef46db3751d8e999"},
+		// RFC 4231, test case 2
+		{"hmac", NewHMACSigner(sha256.New, []byte("Jefe")), "what do ya want for nothing?",
+			"5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"},
+	}
+
+	for _, tc := range testCases {
+		res, err := tc.signer.Sign(tc.data)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.name, err)
+		}
+		if res != tc.expected {
+			t.Errorf("%s: results not match\nGot: %v\nExpected: %v", tc.name, res, tc.expected)
+		}
+	}
+}
+
+func TestBLAKE2bSignerBadKey(t *testing.T) {
+	if _, err := NewBLAKE2bSigner(make([]byte, 65)); err == nil {
+		t.Errorf("expected error for too long key")
+	}
+}
+
+func TestPipelineWithSigners(t *testing.T) {
+	digest := NewHMACSigner(sha256.New, []byte("secret"))
+	checksum := NewXXHashSigner()
+	inputData := []int{0, 1, 1, 2, 3, 5, 8}
+
+	// the same composition done sequentially
+	expected := make([]string, 0, len(inputData))
+	for _, num := range inputData {
+		data := strconv.Itoa(num)
+		bare, _ := checksum.Sign(data)
+		dig, _ := digest.Sign(data)
+		dig, _ = checksum.Sign(dig)
+		single := bare + "~" + dig
+		multi := ""
+		for th := 0; th < 6; th++ {
+			res, _ := checksum.Sign(strconv.Itoa(th) + single)
+			multi += res
+		}
+		expected = append(expected, multi)
+	}
+	sort.Strings(expected)
+
+	var result string
+	ExecutePipeline(
+		job(func(in, out chan interface{}) {
+			for _, num := range inputData {
+				out <- num
+			}
+		}),
+		SingleHashWith(digest, checksum),
+		MultiHashWith(checksum),
+		job(CombineResults),
+		job(func(in, out chan interface{}) {
+			result, _ = (<-in).(string)
+		}),
+	)
+
+	if result != strings.Join(expected, "_") {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, strings.Join(expected, "_"))
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TraceEvent - event of Chrome trace format (chrome://tracing, ui.perfetto.dev).
+// Every item is shown as a process: stage spans in thread 0, signer calls in threads 1, 2, ...
+type TraceEvent struct {
+	Name  string                 `json:"name"`
+	Cat   string                 `json:"cat,omitempty"`
+	Phase string                 `json:"ph"`
+	Ts    int64                  `json:"ts"`
+	Dur   int64                  `json:"dur"`
+	Pid   uint64                 `json:"pid"`
+	Tid   uint64                 `json:"tid"`
+	Args  map[string]interface{} `json:"args,omitempty"`
+}
+
+// Tracer - collects spans of items flowing through pipelines run with it
+// (PipelineOptions.Tracer, PipelineTracer). Item carries its trace from stage
+// to stage inside the pipeline, items made by the first stage start new traces.
+type Tracer struct {
+	mu        sync.Mutex
+	start     time.Time
+	nextTrace uint64
+	lanes     map[uint64]uint64
+	events    []TraceEvent
+}
+
+// PipelineTracer - tracer of pipelines run by ExecutePipeline, nil disables tracing
+var PipelineTracer *Tracer
+
+// tracedItem - item with its trace, stages of a traced pipeline pass these
+// to each other, jobs see the value only
+type tracedItem struct {
+	traceID uint64
+	value   interface{}
+}
+
+// NewTracer - timestamps are counted from now by SignerClock
+func NewTracer() *Tracer {
+	return &Tracer{
+		start: SignerClock.Now(),
+		lanes: make(map[uint64]uint64),
+	}
+}
+
+// Span - work done by a stage for a single item: from the moment its job takes
+// the item until it takes the next one or finishes, and until goroutines
+// started for the item by goWorker are over
+type Span struct {
+	tracer  *Tracer
+	traceID uint64
+	name    string
+	item    interface{}
+	start   time.Time
+	// refs - job and workers still busy with the item
+	refs int32
+}
+
+func (t *Tracer) micros(at time.Time) int64 {
+	return int64(at.Sub(t.start) / time.Microsecond)
+}
+
+func (t *Tracer) record(e TraceEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.events = append(t.events, e)
+}
+
+// newTrace - trace of an item which came from no traced stage
+func (t *Tracer) newTrace(dataRaw interface{}) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.nextTrace++
+	t.events = append(t.events, TraceEvent{
+		Name:  "process_name",
+		Phase: "M",
+		Pid:   t.nextTrace,
+		Args:  map[string]interface{}{"name": fmt.Sprintf("item %v", dataRaw)},
+	})
+	return t.nextTrace
+}
+
+// open - span of stage name for item of the trace, held by the caller
+func (t *Tracer) open(traceID uint64, name string, dataRaw interface{}) *Span {
+	return &Span{
+		tracer:  t,
+		traceID: traceID,
+		name:    name,
+		item:    dataRaw,
+		start:   SignerClock.Now(),
+		refs:    1,
+	}
+}
+
+func (t *Tracer) nextLane(traceID uint64) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lanes[traceID]++
+	return t.lanes[traceID]
+}
+
+// SpanStage - per-item stage, build is called for every item with the span
+// of the item, so signers of the job can be wrapped by span.Signer.
+// Span is nil outside of traced pipelines.
+func SpanStage(build func(span *Span) job) job {
+	return func(in, out chan interface{}) {
+		wg := &sync.WaitGroup{}
+		for dataRaw := range in {
+			wg.Add(1)
+			dataRaw := dataRaw
+			goSpanWorker(out, dataRaw, func(out chan<- interface{}, span *Span) {
+				defer wg.Done()
+				runItem(build(span), dataRaw, func(value interface{}) {
+					out <- value
+				})
+			})
+		}
+		wg.Wait()
+	}
+}
+
+func (s *Span) retain() {
+	atomic.AddInt32(&s.refs, 1)
+}
+
+// release - span ends once nobody is busy with its item
+func (s *Span) release() {
+	if s != nil && atomic.AddInt32(&s.refs, -1) == 0 {
+		s.end()
+	}
+}
+
+func (s *Span) end() {
+	s.tracer.record(TraceEvent{
+		Name:  s.name,
+		Cat:   "stage",
+		Phase: "X",
+		Ts:    s.tracer.micros(s.start),
+		Dur:   int64(SignerClock.Now().Sub(s.start) / time.Microsecond),
+		Pid:   s.traceID,
+		Args:  map[string]interface{}{"item": fmt.Sprint(s.item)},
+	})
+}
+
+type tracedSigner struct {
+	span   *Span
+	name   string
+	signer Signer
+}
+
+func (ts tracedSigner) Sign(data string) (string, error) {
+	lane := ts.span.tracer.nextLane(ts.span.traceID)
+	start := SignerClock.Now()
+	res, err := ts.signer.Sign(data)
+	args := map[string]interface{}{"stage": ts.span.name, "data": data}
+	if err != nil {
+		args["error"] = err.Error()
+	}
+	ts.span.tracer.record(TraceEvent{
+		Name:  ts.name,
+		Cat:   "signer",
+		Phase: "X",
+		Ts:    ts.span.tracer.micros(start),
+		Dur:   int64(SignerClock.Now().Sub(start) / time.Microsecond),
+		Pid:   ts.span.traceID,
+		Tid:   lane,
+		Args:  args,
+	})
+	return res, err
+}
+
+// Signer - wraps signer so its calls are recorded as children of the span,
+// nil span leaves it as is
+func (s *Span) Signer(name string, signer Signer) Signer {
+	if s == nil {
+		return signer
+	}
+	return tracedSigner{span: s, name: name, signer: signer}
+}
+
+// Events - copy of recorded events
+func (t *Tracer) Events() []TraceEvent {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	events := make([]TraceEvent, len(t.events))
+	copy(events, t.events)
+	return events
+}
+
+// WriteJSON - writes events in Chrome trace format
+func (t *Tracer) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(struct {
+		TraceEvents     []TraceEvent `json:"traceEvents"`
+		DisplayTimeUnit string       `json:"displayTimeUnit"`
+	}{t.Events(), "ms"})
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTracerPipeline(t *testing.T) {
+	fc, restore := useFakeClock()
+	defer restore()
+
+	tracer := NewTracer()
+	runOnFakeClock(t, fc, func() {
+		stages, err := BuildPipeline(DefaultPipelineConfig(), BuildOptions{Trace: true})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+			return
+		}
+		jobs := append([]job{numbersJob(2)}, stages...)
+		jobs = append(jobs, job(func(in, out chan interface{}) {
+			for range in {
+			}
+		}))
+		opts := PipelineOptions{
+			StageNames: []string{"input", "SingleHash", "MultiHash", "CombineResults", "output"},
+			Tracer:     tracer,
+		}
+		if err := ExecutePipelineWith(opts, jobs...); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	})
+
+	type traceStats struct {
+		name    string
+		spans   map[string]int
+		signers map[string]int
+	}
+	traces := map[uint64]*traceStats{}
+	for _, e := range tracer.Events() {
+		ts, ok := traces[e.Pid]
+		if !ok {
+			ts = &traceStats{spans: map[string]int{}, signers: map[string]int{}}
+			traces[e.Pid] = ts
+		}
+		switch e.Cat {
+		case "":
+			ts.name = e.Args["name"].(string)
+		case "stage":
+			ts.spans[e.Name]++
+		case "signer":
+			ts.signers[e.Args["stage"].(string)+"/"+e.Name]++
+			if e.Name == "crc32" && e.Dur != int64(time.Second/time.Microsecond) {
+				t.Errorf("crc32 call should take 1s, got %dus", e.Dur)
+			}
+		}
+	}
+
+	if len(traces) != 2 {
+		t.Fatalf("expected 2 traces, got %d", len(traces))
+	}
+	outputs := 0
+	for pid, ts := range traces {
+		if ts.name != "item 0" && ts.name != "item 1" {
+			t.Errorf("trace %d: unexpected name %q", pid, ts.name)
+		}
+		if ts.spans["SingleHash"] != 1 || ts.spans["MultiHash"] != 1 || ts.spans["CombineResults"] != 1 {
+			t.Errorf("trace %d: unexpected spans %v", pid, ts.spans)
+		}
+		// combined result goes on with the item CombineResults took last
+		outputs += ts.spans["output"]
+		if ts.signers["SingleHash/md5"] != 1 || ts.signers["SingleHash/crc32"] != 2 || ts.signers["MultiHash/crc32"] != 6 {
+			t.Errorf("trace %d: unexpected signer calls %v", pid, ts.signers)
+		}
+	}
+	if outputs != 1 {
+		t.Errorf("expected 1 output span, got %d", outputs)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := tracer.WriteJSON(buf); err != nil {
+		t.Fatalf("cant write trace: %s", err)
+	}
+	exported := struct {
+		TraceEvents []TraceEvent `json:"traceEvents"`
+	}{}
+	if err := json.Unmarshal(buf.Bytes(), &exported); err != nil {
+		t.Fatalf("bad trace json: %s", err)
+	}
+	if len(exported.TraceEvents) != len(tracer.Events()) {
+		t.Errorf("expected %d events, got %d", len(tracer.Events()), len(exported.TraceEvents))
+	}
+}
+
+func TestTracerItems(t *testing.T) {
+	tracer := NewTracer()
+	defer func(tracer *Tracer) { PipelineTracer = tracer }(PipelineTracer)
+	PipelineTracer = tracer
+
+	// equal items and items which are neither int nor string
+	items := []interface{}{"x", "x", []string{"y"}}
+	// second item is done first, then the first one, then the third one
+	done := []chan struct{}{make(chan struct{}), make(chan struct{}), make(chan struct{})}
+	after := []int{1, -1, 0}
+	var finished int32
+	ExecutePipeline(
+		job(func(in, out chan interface{}) {
+			for _, item := range items {
+				out <- item
+			}
+		}),
+		job(func(in, out chan interface{}) {
+			for dataRaw := range in {
+				out <- dataRaw
+			}
+		}),
+		job(func(in, out chan interface{}) {
+			wg := &sync.WaitGroup{}
+			taken := 0
+			for dataRaw := range in {
+				wg.Add(1)
+				dataRaw, n := dataRaw, taken
+				taken++
+				goWorker(out, dataRaw, func(out chan<- interface{}) {
+					defer wg.Done()
+					if after[n] >= 0 {
+						<-done[after[n]]
+					}
+					out <- fmt.Sprintf("%v done %d", dataRaw, atomic.AddInt32(&finished, 1))
+					close(done[n])
+				})
+			}
+			wg.Wait()
+		}),
+		job(func(in, out chan interface{}) {
+			for range in {
+			}
+		}),
+	)
+
+	names := map[uint64]string{}
+	spans := map[uint64]map[string]string{}
+	for _, e := range tracer.Events() {
+		if e.Cat == "" {
+			names[e.Pid] = e.Args["name"].(string)
+			continue
+		}
+		if spans[e.Pid] == nil {
+			spans[e.Pid] = map[string]string{}
+		}
+		if _, ok := spans[e.Pid][e.Name]; ok {
+			t.Errorf("trace %d: second span of %s", e.Pid, e.Name)
+		}
+		spans[e.Pid][e.Name] = e.Args["item"].(string)
+	}
+
+	expected := []map[string]string{
+		{"stage 1": "x", "stage 2": "x", "stage 3": "x done 2"},
+		{"stage 1": "x", "stage 2": "x", "stage 3": "x done 1"},
+		{"stage 1": "[y]", "stage 2": "[y]", "stage 3": "[y] done 3"},
+	}
+	if len(names) != len(expected) || len(spans) != len(expected) {
+		t.Fatalf("expected %d traces, got %v", len(expected), names)
+	}
+	for i, exp := range expected {
+		pid := uint64(i + 1)
+		if names[pid] != "item "+exp["stage 1"] {
+			t.Errorf("trace %d: unexpected name %q", pid, names[pid])
+		}
+		if !reflect.DeepEqual(spans[pid], exp) {
+			t.Errorf("trace %d: unexpected spans %v, expected %v", pid, spans[pid], exp)
+		}
+	}
+}
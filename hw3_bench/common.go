@@ -18,11 +18,20 @@ func SlowSearch(out io.Writer) {
 	if err != nil {
 		panic(err)
 	}
+	defer file.Close()
 
-	fileContents, err := ioutil.ReadAll(file)
-	if err != nil {
+	if err := SlowSearchReader(out, file, &LinePolicy{Strict: true}); err != nil {
 		panic(err)
 	}
+}
+
+// SlowSearchReader - SlowSearch over users dump read from in, malformed
+// lines are handled according to policy, nil policy skips them silently
+func SlowSearchReader(out io.Writer, in io.Reader, policy *LinePolicy) error {
+	fileContents, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
 
 	r := regexp.MustCompile("@")
 	seenBrowsers := []string{}
@@ -30,16 +39,27 @@ func SlowSearch(out io.Writer) {
 	foundUsers := ""
 
 	lines := strings.Split(string(fileContents), "\n")
+	if lines[len(lines)-1] == "" {
+		// dump ends with newline or is empty
+		lines = lines[:len(lines)-1]
+	}
 
+	checker := newLineChecker(policy)
+	offset := int64(0)
 	users := make([]map[string]interface{}, 0)
-	for _, line := range lines {
+	for i, line := range lines {
 		user := make(map[string]interface{})
 		// fmt.Printf("%v %v\n", err, line)
 		err := json.Unmarshal([]byte(line), &user)
 		if err != nil {
-			panic(err)
+			if err := checker.reject(i, offset, []byte(line), err); err != nil {
+				return err
+			}
+			// keeps numbering of the lines after it
+			user = map[string]interface{}{}
 		}
 		users = append(users, user)
+		offset += int64(len(line)) + 1
 	}
 
 	for i, user := range users {
@@ -102,10 +122,17 @@ func SlowSearch(out io.Writer) {
 		}
 
 		// log.Println("Android and MSIE user:", user["name"], user["email"])
-		email := r.ReplaceAllString(user["email"].(string), " [at] ")
-		foundUsers += fmt.Sprintf("[%d] %s <%s>\n", i, user["name"], email)
+		// missing or null name and email are empty
+		name, _ := user["name"].(string)
+		email, _ := user["email"].(string)
+		email = r.ReplaceAllString(email, " [at] ")
+		foundUsers += fmt.Sprintf("[%d] %s <%s>\n", i, name, email)
 	}
 
 	fmt.Fprintln(out, "found users:\n"+foundUsers)
 	fmt.Fprintln(out, "Total unique browsers", len(seenBrowsers))
+	if checker.malformed != 0 {
+		fmt.Fprintln(out, "Malformed lines", checker.malformed)
+	}
+	return nil
 }
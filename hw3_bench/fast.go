@@ -3,13 +3,10 @@ package main
 import (
 	"bufio"
 	"encoding/json"
-	"sync"
 
 	//json "encoding/json"
-	"fmt"
 	"io"
 	"os"
-	"strings"
 
 	easyjson "github.com/mailru/easyjson"
 	jlexer "github.com/mailru/easyjson/jlexer"
@@ -160,72 +157,99 @@ func (v *User) UnmarshalEasyJSON(l *jlexer.Lexer) {
 
 // вам надо написать более быструю оптимальную этой функции
 func FastSearch(out io.Writer) {
-	var dataPool = sync.Pool{
-		New: func() interface{} {
-			return make([]byte, 0, 500)
-		},
+	if err := FastSearchFile(NewTextWriter(out), filePath, defaultQuery, nil); err != nil {
+		panic(err)
+	}
+}
+
+var defaultQuery = MustParseQuery(DefaultQuery)
+
+// FastSearchFile - FastSearchQuery over users dump at path, plain or compressed.
+// Large plain files are mapped into memory where it is supported.
+func FastSearchFile(w ResultWriter, path string, q *Query, policy *LinePolicy) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
 	}
-	file, err := os.Open(filePath)
 	defer file.Close()
+
+	if data := mapInput(file); data != nil {
+		defer munmap(data)
+		return searchMapped(w, file, data, q, policy)
+	}
+	r, err := NewInputReader(file)
 	if err != nil {
-		panic(err)
+		return err
 	}
+	defer r.Close()
+	return FastSearchQuery(w, r, q, policy)
+}
 
-	reader := bufio.NewReader(file)
-	cnt := 0
-
-	seenBrowsers := make([]string, 0, 115)
-
-	fmt.Fprintln(out, "found users:")
-	u := &User{}
-	for {
-		cnt++
-		str := dataPool.Get().([]byte)
-		str, err = reader.ReadBytes('\n')
-		u.UnmarshalJSON(str)
-		dataPool.Put(str)
-		isAndroid := false
-		isMSIE := false
-
-		for _, browser := range u.Browsers {
-			if strings.Contains(browser, "Android") {
-				isAndroid = true
-				notSeenBefore := true
-				for _, item := range seenBrowsers {
-					if item == browser {
-						notSeenBefore = false
-					}
-				}
-				if notSeenBefore {
-					// log.Printf("SLOW New browser: %s, first seen: %s", browser, user["name"])
-					seenBrowsers = append(seenBrowsers, browser)
-				}
-			}
+// lineSearch - FastSearchQuery of one line, shared by ways of reading lines
+type lineSearch struct {
+	w       ResultWriter
+	q       *Query
+	seen    *browserSet
+	scanner *userScanner
+	checker *lineChecker
+}
 
-			if strings.Contains(browser, "MSIE") {
-				isMSIE = true
-				notSeenBefore := true
-				for _, item := range seenBrowsers {
-					if item == browser {
-						notSeenBefore = false
-					}
-				}
-				if notSeenBefore {
-					// log.Printf("SLOW New browser: %s, first seen: %s", browser, user["name"])
-					seenBrowsers = append(seenBrowsers, browser)
-				}
-			}
-		}
+func newLineSearch(w ResultWriter, q *Query, policy *LinePolicy) *lineSearch {
+	ls := &lineSearch{w: w, q: q, seen: newBrowserSet(), checker: newLineChecker(policy)}
+	ls.scanner = newUserScanner(q, ls.seen)
+	return ls
+}
 
-		if isAndroid && isMSIE {
-			u.Email = strings.Replace(u.Email, "@", " [at] ", -1)
-			fmt.Fprintf(out, "[%d] %s <%s>\n", cnt-1, u.Name, u.Email)
-		}
+// search - line idx at offset, error means the search must stop
+func (ls *lineSearch) search(idx int, offset int64, line []byte) error {
+	if !ls.checker.checkAll() && ls.q.skipRaw(line) {
+		return nil
+	}
+	name, email, ok, scanErr := ls.scanner.scan(line)
+	if scanErr != nil {
+		return ls.checker.reject(idx, offset, line, scanErr)
+	}
+	if ok {
+		return ls.w.Found(idx, name, email)
+	}
+	return nil
+}
 
-		// fmt.Printf("%d: %s %s %d\n", cnt, u.Name, u.Email, len(u.Browsers))
-		if err == io.EOF {
+func (ls *lineSearch) stats() SearchStats {
+	return SearchStats{UniqueBrowsers: ls.seen.Len(), MalformedLines: ls.checker.malformed}
+}
+
+// FastSearchQuery - FastSearch for users matching q over users dump read from r,
+// malformed lines are handled according to policy
+func FastSearchQuery(w ResultWriter, r io.Reader, q *Query, policy *LinePolicy) error {
+	ls := newLineSearch(w, q, policy)
+	if err := w.Start(); err != nil {
+		return err
+	}
+	if err := ls.searchLines(r, 0, 0); err != nil {
+		return err
+	}
+	return w.Finish(ls.stats())
+}
+
+// searchLines - searches lines read from r, the first of them is line cnt at offset
+func (ls *lineSearch) searchLines(r io.Reader, cnt int, offset int64) error {
+	reader := bufio.NewReaderSize(r, 64<<10)
+	var line, longLine []byte
+	var err error
+	for ; err != io.EOF; cnt++ {
+		line, longLine, err = readLine(reader, longLine)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if err == io.EOF && len(line) == 0 {
+			// dump ends with newline
 			break
 		}
+		if err := ls.search(cnt, offset, line); err != nil {
+			return err
+		}
+		offset += int64(len(line))
 	}
-	fmt.Fprintln(out, "\nTotal unique browsers", len(seenBrowsers))
+	return nil
 }
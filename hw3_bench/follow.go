@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"time"
+)
+
+const defaultFollowPoll = 250 * time.Millisecond
+
+// FollowOptions - how Follow watches the users file
+type FollowOptions struct {
+	// FromEnd - only lines appended after Follow started are searched,
+	// their indexes still count the lines before
+	FromEnd bool
+	// Poll - how often the file is checked for new lines and rotation, 250ms if 0
+	Poll time.Duration
+	// Progress - called with running totals when they change, after new lines are searched
+	Progress func(stats SearchStats)
+	// Stop - Follow finishes the search once it is closed
+	Stop   <-chan struct{}
+	Policy *LinePolicy
+	// polled - called after every check of the file with the number of its
+	// bytes read so far, tests wait on it instead of sleeping
+	polled func(read int64)
+}
+
+// resultFlusher - ResultWriter buffering results, Follow flushes it after new lines
+type resultFlusher interface {
+	Flush() error
+}
+
+// follower - state of one Follow, line numbers go on across rotations
+type follower struct {
+	w    ResultWriter
+	opts FollowOptions
+	path string
+	ls   *lineSearch
+
+	file   *os.File
+	stat   os.FileInfo
+	reader *bufio.Reader
+	// offset - in the current file, of the next line
+	offset int64
+	line   int
+	// pending - start of a line the writer has not finished yet
+	pending  []byte
+	longLine []byte
+
+	reported SearchStats
+}
+
+// Follow - FastSearchQuery over the file at path which keeps reading lines
+// appended to it, like tail -f, until opts.Stop is closed. A line is searched
+// once its newline arrives. When the file is replaced (its inode changes) the
+// rest of the old file is searched and the new one is followed from the start,
+// a truncated file is followed from the start too.
+func Follow(w ResultWriter, path string, q *Query, opts FollowOptions) error {
+	if opts.Poll <= 0 {
+		opts.Poll = defaultFollowPoll
+	}
+	f := &follower{w: w, opts: opts, path: path, ls: newLineSearch(w, q, opts.Policy)}
+	if err := f.open(); err != nil {
+		return err
+	}
+	defer func() {
+		f.file.Close()
+	}()
+	if opts.FromEnd {
+		if err := f.skipToEnd(); err != nil {
+			return err
+		}
+	}
+
+	if err := w.Start(); err != nil {
+		return err
+	}
+	for {
+		if err := f.readLines(); err != nil {
+			return err
+		}
+		if err := f.report(); err != nil {
+			return err
+		}
+		if opts.polled != nil {
+			opts.polled(f.offset + int64(len(f.pending)))
+		}
+		select {
+		case <-opts.Stop:
+			return w.Finish(f.ls.stats())
+		case <-time.After(opts.Poll):
+		}
+		if err := f.checkRotation(); err != nil {
+			return err
+		}
+	}
+}
+
+func (f *follower) open() error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.stat, f.offset = file, stat, 0
+	if f.reader == nil {
+		f.reader = bufio.NewReaderSize(file, 64<<10)
+	} else {
+		f.reader.Reset(file)
+	}
+	return nil
+}
+
+// skipToEnd - counts lines already in the file without searching them
+func (f *follower) skipToEnd() error {
+	for {
+		var line []byte
+		var err error
+		line, f.longLine, err = readLine(f.reader, f.longLine)
+		if err == io.EOF {
+			// the last line may be not finished yet, it is searched when it is
+			f.pending = append(f.pending[:0], line...)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		f.line++
+		f.offset += int64(len(line))
+	}
+}
+
+// readLines - searches every finished line up to the end of the file
+func (f *follower) readLines() error {
+	for {
+		select {
+		case <-f.opts.Stop:
+			return nil
+		default:
+		}
+
+		var line []byte
+		var err error
+		line, f.longLine, err = readLine(f.reader, f.longLine)
+		if err == io.EOF {
+			// line will be finished later, reader buffer is reused before that
+			f.pending = append(f.pending, line...)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(f.pending) != 0 {
+			f.pending = append(f.pending, line...)
+			line = f.pending
+		}
+		if err := f.search(line); err != nil {
+			return err
+		}
+		f.pending = f.pending[:0]
+	}
+}
+
+func (f *follower) search(line []byte) error {
+	lineOffset := f.offset
+	f.offset += int64(len(line))
+	f.line++
+	return f.ls.search(f.line-1, lineOffset, line)
+}
+
+// report - flushes results found since the last report and running totals
+func (f *follower) report() error {
+	if flusher, ok := f.w.(resultFlusher); ok {
+		if err := flusher.Flush(); err != nil {
+			return err
+		}
+	}
+	if stats := f.ls.stats(); stats != f.reported && f.opts.Progress != nil {
+		f.opts.Progress(stats)
+		f.reported = stats
+	}
+	return nil
+}
+
+// checkRotation - switches to the new file at path if the old one was
+// replaced, starts over if it was truncated
+func (f *follower) checkRotation() error {
+	stat, err := os.Stat(f.path)
+	if os.IsNotExist(err) {
+		// rotated, the new file is not there yet
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if os.SameFile(stat, f.stat) {
+		if stat.Size() >= f.offset+int64(len(f.pending)) {
+			return nil
+		}
+		// truncated in place, its unfinished line is gone too
+		f.pending = f.pending[:0]
+		if _, err := f.file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		f.reader.Reset(f.file)
+		f.offset = 0
+		return nil
+	}
+
+	// lines written to the old file before it was replaced
+	if err := f.readLines(); err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(f.pending)) != 0 {
+		// nobody finishes the last line of a rotated file
+		if err := f.search(f.pending); err != nil {
+			return err
+		}
+	}
+	f.pending = f.pending[:0]
+	f.file.Close()
+	return f.open()
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lockedBuffer - results written by Follow goroutine and read by the test
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (lb *lockedBuffer) Write(p []byte) (int, error) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	return lb.buf.Write(p)
+}
+
+func (lb *lockedBuffer) String() string {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	return lb.buf.String()
+}
+
+// followedUsers - Follow of path running in background
+type followedUsers struct {
+	out      *lockedBuffer
+	stop     chan struct{}
+	done     chan error
+	mu       sync.Mutex
+	progress []SearchStats
+	// read - bytes of the followed file read by the last poll
+	read int64
+}
+
+func startFollow(t *testing.T, path string, format OutputFormat, fromEnd bool) *followedUsers {
+	fu := &followedUsers{out: &lockedBuffer{}, stop: make(chan struct{}), done: make(chan error, 1)}
+	opts := FollowOptions{
+		FromEnd: fromEnd,
+		Poll:    time.Millisecond,
+		Stop:    fu.stop,
+		Policy:  &LinePolicy{},
+		Progress: func(stats SearchStats) {
+			fu.mu.Lock()
+			fu.progress = append(fu.progress, stats)
+			fu.mu.Unlock()
+		},
+		polled: func(read int64) {
+			fu.mu.Lock()
+			fu.read = read
+			fu.mu.Unlock()
+		},
+	}
+	go func() {
+		fu.done <- Follow(NewResultWriter(fu.out, format, EmailNone), path, defaultQuery, opts)
+	}()
+	return fu
+}
+
+// waitFor - results of Follow become expected, fails after a few seconds
+func (fu *followedUsers) waitFor(t *testing.T, expected string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for fu.out.String() != expected {
+		if time.Now().After(deadline) {
+			t.Fatalf("results not match\nGot:\n%v\nExpected:\n%v", fu.out, expected)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waitRead - Follow has read size bytes of the current file, fails after a few seconds
+func (fu *followedUsers) waitRead(t *testing.T, size int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		fu.mu.Lock()
+		read := fu.read
+		fu.mu.Unlock()
+		if read == int64(size) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d bytes read, got %d", size, read)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (fu *followedUsers) finish(t *testing.T) {
+	close(fu.stop)
+	if err := <-fu.done; err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// expectedFound - jsonl results of FastSearch over lines
+func expectedFound(t *testing.T, lines []string) string {
+	out := new(bytes.Buffer)
+	err := FastSearchQuery(NewResultWriter(out, FormatJSONLines, EmailNone), strings.NewReader(strings.Join(lines, "\n")), defaultQuery, &LinePolicy{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return out.String()
+}
+
+func appendFile(t *testing.T, path, data string) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(data); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestFollow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hw3")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "users.txt")
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	lines := strings.Split(string(data), "\n")
+
+	appendFile(t, path, strings.Join(lines[:400], "\n")+"\n")
+	fu := startFollow(t, path, FormatJSONLines, false)
+	fu.waitFor(t, expectedFound(t, lines[:400]))
+
+	// the writer is in the middle of a line
+	batch := strings.Join(lines[400:600], "\n") + "\n"
+	appendFile(t, path, batch[:len(batch)/2])
+	fu.waitRead(t, len(strings.Join(lines[:400], "\n"))+1+len(batch)/2)
+	appendFile(t, path, batch[len(batch)/2:])
+	fu.waitFor(t, expectedFound(t, lines[:600]))
+
+	// rotation: the old file gets lines after it is renamed, the last one unfinished
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	appendFile(t, path+".1", strings.Join(lines[600:800], "\n"))
+	appendFile(t, path, strings.Join(lines[800:], "\n")+"\n")
+	fu.waitFor(t, expectedFound(t, lines))
+
+	fu.finish(t)
+	fu.mu.Lock()
+	defer fu.mu.Unlock()
+	last := fu.progress[len(fu.progress)-1]
+	if last.UniqueBrowsers != 114 || len(fu.progress) < 3 {
+		t.Errorf("unexpected progress %v", fu.progress)
+	}
+	for i := 1; i < len(fu.progress); i++ {
+		if fu.progress[i].UniqueBrowsers < fu.progress[i-1].UniqueBrowsers {
+			t.Errorf("unique browsers count went down: %v", fu.progress)
+		}
+	}
+}
+
+func TestFollowTruncateFromEnd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hw3")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "users.txt")
+	user := `{"browsers":["Android 4","MSIE 8"],"email":"a@b.c","name":"A"}`
+	other := `{"browsers":["Opera"],"email":"x@y.z","name":"X"}`
+
+	appendFile(t, path, user+"\n"+other+"\n"+user)
+	fu := startFollow(t, path, FormatCSV, true)
+	fu.waitRead(t, len(user+"\n"+other+"\n"+user))
+	if out := fu.out.String(); out != "index,name,email\n" {
+		t.Fatalf("lines before the start are searched: %s", out)
+	}
+
+	// the unfinished line is finished, then a new one comes, a malformed line
+	// counts in the running totals too
+	appendFile(t, path, "\nbroken\n"+user+"\n")
+	fu.waitFor(t, "index,name,email\n2,A,a@b.c\n4,A,a@b.c\n")
+
+	// copytruncate rotation starts the file over, numbering goes on
+	if err := ioutil.WriteFile(path, []byte(other+"\n"), 0644); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// the file read before was longer, so this is after the follower started over
+	fu.waitRead(t, len(other)+1)
+	appendFile(t, path, user+"\n")
+	fu.waitFor(t, "index,name,email\n2,A,a@b.c\n4,A,a@b.c\n6,A,a@b.c\n")
+
+	fu.finish(t)
+	fu.mu.Lock()
+	defer fu.mu.Unlock()
+	if last := fu.progress[len(fu.progress)-1]; last.UniqueBrowsers != 2 || last.MalformedLines != 1 {
+		t.Errorf("unexpected progress %v", fu.progress)
+	}
+}
+
+func TestFollowErrors(t *testing.T) {
+	if err := Follow(NewTextWriter(ioutil.Discard), "./data/missing.txt", defaultQuery, FollowOptions{}); err == nil {
+		t.Errorf("expected error for missing file")
+	}
+	if err := run([]string{"search", "-follow", "-workers", "2"}, ioutil.Discard); err == nil {
+		t.Errorf("expected error for follow with workers")
+	}
+}
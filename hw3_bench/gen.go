@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// GeneratorOptions - shape of a generated users dump, same options and seed
+// give the same dump
+type GeneratorOptions struct {
+	Seed  int64
+	Users int
+	// Browsers - size of the pool users take browsers from, 200 if 0
+	Browsers int
+	// MaxBrowsers - every user has 0..MaxBrowsers browsers, 6 if 0
+	MaxBrowsers int
+	// Skew - Zipf exponent of browsers popularity, values up to 1 are uniform
+	Skew float64
+	// EdgeCases - share of users with empty or missing browsers, missing
+	// and null fields, unicode, escapes, unknown nested fields and odd spacing
+	EdgeCases float64
+	// NoFinalNewline - last line is not terminated, as in data/users.txt
+	NoFinalNewline bool
+}
+
+// browserTemplates - # is replaced by a random number, so the pool has both
+// Android and MSIE browsers and browsers having both
+var browserTemplates = []string{
+	"Mozilla/4.0 (compatible; MSIE #.0; Windows NT #.#)",
+	"Mozilla/5.0 (compatible; MSIE #.0; Windows Phone OS 7.#; Trident/5.0; IEMobile/#.0)",
+	"Mozilla/4.0 (compatible; MSIE #.0; Linux; Android #.#; Nexus #)",
+	"Mozilla/5.0 (Linux; Android #.#; SM-G# Build/KOT#) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/#.0.#.94 Mobile Safari/537.36",
+	"Mozilla/5.0 (Linux; U; Android #.#; en-us; Build/FROYO) AppleWebKit/533.1 (KHTML, like Gecko) Version/#.0 Safari/533.1",
+	"Mozilla/5.0 (Windows NT #.#; WOW64; rv:#.0) Gecko/20100101 Firefox/#.0",
+	"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/#.0.#.0 Safari/537.36",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_#_#) AppleWebKit/600.# (KHTML, like Gecko) Version/#.0 Safari/600.#",
+	"Mozilla/5.0 (iPhone; CPU iPhone OS #_# like Mac OS X) AppleWebKit/601.# (KHTML, like Gecko) Version/#.0 Mobile/13C# Safari/601.1",
+	"Opera/9.80 (Android; Opera Mini/#.#.#/31.#; U; en) Presto/2.8.# Version/11.#",
+	"Opera/9.80 (Windows NT #.#; U; en) Presto/2.#.# Version/#.#",
+	"Lynx/2.8.#rel.# libwww-FM/2.# SSL-MM/1.4.#",
+	"Googlebot/2.# ( http://www.googlebot.com/bot.html)",
+}
+
+// unicodeBrowsers - edge case browsers, some of them match the default query
+var unicodeBrowsers = []string{
+	"Mozilla/5.0 (Android #.#; ru-RU; Тест) MSIE #.0 — “quoted”",
+	"Mozilla/5.0 (Linux; Android #; 日本語) AppleWebKit/537.# 😀",
+	"Mozilla/4.0 (compatible; MSIE #.0; Windows \"XP\" \\ tab\t)",
+	"",
+	"Android",
+	"MSIE",
+}
+
+var (
+	firstNames = []string{"Sharon", "Jonathan", "Ann", "Jack", "Maria", "Pavel", "Zoë", "Ægir", "Дмитрий", "王芳", "O'Brien"}
+	lastNames  = []string{"Crawford", "Morris", "Smith", "Lee", "Ångström", "Müller", "Иванов", "🦊", "\"Quoted\"", "Back\\slash"}
+	domains    = []string{"Muxo.edu", "Flashpoint.com", "example.org", "пример.рф", "mail.co.uk"}
+)
+
+type generator struct {
+	opts GeneratorOptions
+	rnd  *rand.Rand
+	zipf *rand.Zipf
+	pool []string
+	buf  []byte
+}
+
+// GenerateUsers - writes a random users dump, one valid JSON user per line
+func GenerateUsers(w io.Writer, opts GeneratorOptions) error {
+	if opts.Browsers <= 0 {
+		opts.Browsers = 200
+	}
+	if opts.MaxBrowsers <= 0 {
+		opts.MaxBrowsers = 6
+	}
+	g := &generator{opts: opts, rnd: rand.New(rand.NewSource(opts.Seed))}
+	for i := 0; i < opts.Browsers; i++ {
+		g.pool = append(g.pool, g.fill(browserTemplates[g.rnd.Intn(len(browserTemplates))]))
+	}
+	if opts.Skew > 1 {
+		g.zipf = rand.NewZipf(g.rnd, opts.Skew, 1, uint64(len(g.pool)-1))
+	}
+
+	bw := bufio.NewWriter(w)
+	for i := 0; i < opts.Users; i++ {
+		g.buf = g.user(g.buf[:0])
+		if i+1 < opts.Users || !opts.NoFinalNewline {
+			g.buf = append(g.buf, '\n')
+		}
+		if _, err := bw.Write(g.buf); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// fill - template with every # replaced by a random number
+func (g *generator) fill(template string) string {
+	parts := strings.Split(template, "#")
+	buf := []byte(parts[0])
+	for _, part := range parts[1:] {
+		buf = strconv.AppendInt(buf, int64(g.rnd.Intn(20)), 10)
+		buf = append(buf, part...)
+	}
+	return string(buf)
+}
+
+func (g *generator) edge() bool {
+	return g.rnd.Float64() < g.opts.EdgeCases
+}
+
+func (g *generator) pick(items []string) string {
+	return items[g.rnd.Intn(len(items))]
+}
+
+func (g *generator) browser() string {
+	if g.edge() && g.rnd.Intn(4) == 0 {
+		return g.fill(g.pick(unicodeBrowsers))
+	}
+	if g.zipf != nil {
+		return g.pool[g.zipf.Uint64()]
+	}
+	return g.pool[g.rnd.Intn(len(g.pool))]
+}
+
+// space - odd but valid spacing between JSON tokens of edge case users
+func (g *generator) space(buf []byte, edge bool) []byte {
+	if edge && g.rnd.Intn(3) == 0 {
+		buf = append(buf, g.pick([]string{" ", "\t", "  ", " \t "})...)
+	}
+	return buf
+}
+
+func (g *generator) user(buf []byte) []byte {
+	edge := g.edge()
+	// plain users have plain ASCII names
+	name := g.pick(firstNames[:6]) + " " + g.pick(lastNames[:4])
+	email := strings.Replace(name, " ", "", -1) + "@" + g.pick(domains[:3])
+	if edge {
+		name = g.pick(firstNames) + " " + g.pick(lastNames)
+		email = strings.Replace(name, " ", ".", -1) + "@" + g.pick(domains)
+	}
+
+	keys := []string{"browsers", "company", "country", "email", "job", "name", "phone"}
+	if edge {
+		g.rnd.Shuffle(len(keys), func(i, j int) { keys[i], keys[j] = keys[j], keys[i] })
+	}
+	buf = g.space(buf, edge)
+	buf = append(buf, '{')
+	first := true
+	for _, key := range keys {
+		if edge && g.rnd.Intn(6) == 0 {
+			// missing field
+			continue
+		}
+		if !first {
+			buf = g.space(buf, edge)
+			buf = append(buf, ',')
+		}
+		first = false
+		buf = g.space(buf, edge)
+		buf = g.appendString(buf, key, false)
+		buf = g.space(buf, edge)
+		buf = append(buf, ':')
+		buf = g.space(buf, edge)
+
+		switch {
+		case edge && g.rnd.Intn(8) == 0:
+			buf = append(buf, "null"...)
+		case key == "browsers":
+			buf = g.browsers(buf, edge)
+		case key == "name":
+			buf = g.appendString(buf, name, edge)
+		case key == "email":
+			buf = g.appendString(buf, email, edge)
+		case edge && g.rnd.Intn(4) == 0:
+			buf = append(buf, `{"nested":[1,2.5e3,true,false,null,{"s":"}]\"{["}],"empty":{}}`...)
+		default:
+			buf = g.appendString(buf, g.fill(key+" #"), edge)
+		}
+	}
+	if edge && g.rnd.Intn(4) == 0 {
+		// unknown field encoders of other versions may add
+		if !first {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, `"tags":["a",["b"],{"browsers":["MSIE Android"]}]`...)
+	}
+	buf = g.space(buf, edge)
+	buf = append(buf, '}')
+	if edge && g.rnd.Intn(4) == 0 {
+		buf = append(buf, g.pick([]string{" ", "\t", "\r"})...)
+	}
+	return buf
+}
+
+func (g *generator) browsers(buf []byte, edge bool) []byte {
+	buf = append(buf, '[')
+	n := g.rnd.Intn(g.opts.MaxBrowsers + 1)
+	for i := 0; i < n; i++ {
+		if i != 0 {
+			buf = g.space(buf, edge)
+			buf = append(buf, ',')
+		}
+		buf = g.space(buf, edge)
+		buf = g.appendString(buf, g.browser(), edge)
+	}
+	buf = g.space(buf, edge)
+	return append(buf, ']')
+}
+
+// appendString - s as JSON string, edge strings escape what encoders may
+// escape: slashes, non-ASCII and HTML characters, and now and then plain
+// letters, which are valid JSON as well and hide needles from raw matching.
+func (g *generator) appendString(buf []byte, s string, edge bool) []byte {
+	buf = append(buf, '"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			buf = append(buf, '\\', byte(r))
+		case r == '\t':
+			buf = append(buf, `\t`...)
+		case r < 0x20:
+			buf = appendUnicodeEscape(buf, r)
+		case edge && (r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') && g.rnd.Intn(16) == 0:
+			buf = appendUnicodeEscape(buf, r)
+		case edge && r == '/' && g.rnd.Intn(2) == 0:
+			buf = append(buf, `\/`...)
+		case edge && (r >= utf8.RuneSelf || r == '<' || r == '>' || r == '&') && g.rnd.Intn(2) == 0:
+			if r1, r2 := utf16.EncodeRune(r); r1 != utf8.RuneError {
+				buf = appendUnicodeEscape(buf, r1)
+				buf = appendUnicodeEscape(buf, r2)
+			} else {
+				buf = appendUnicodeEscape(buf, r)
+			}
+		default:
+			buf = append(buf, string(r)...)
+		}
+	}
+	return append(buf, '"')
+}
+
+func appendUnicodeEscape(buf []byte, r rune) []byte {
+	const hex = "0123456789abcdef"
+	return append(buf, '\\', 'u', hex[r>>12&0xf], hex[r>>8&0xf], hex[r>>4&0xf], hex[r&0xf])
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+const indexVersion = 4
+
+// sumBlockSize - size of the first and last blocks of the source hashed into SourceSum
+const sumBlockSize = 64 << 10
+
+// maxIndexFieldMatchers - queries with more email and name matchers are not analysed
+const maxIndexFieldMatchers = 16
+
+// ErrStaleIndex - users file changed after the index was built
+var ErrStaleIndex = errors.New("index is stale")
+
+// indexFile - what is saved on disk, source size, mtime and sum invalidate the index
+type indexFile struct {
+	Version       int
+	SourceSize    int64
+	SourceModTime int64
+	// SourceSum - hash of the first and last blocks, catches rewrites keeping size and mtime
+	SourceSum uint64
+	// Offsets - start of every line of the source
+	Offsets []int64
+	// Browsers - sorted unique browsers, Postings[i] - lines having Browsers[i]
+	Browsers []string
+	Postings [][]uint32
+	// Malformed - lines which are not valid users JSON, they are not indexed
+	Malformed []uint32
+}
+
+// BrowserIndex - inverted index of users file: browser -> lines of users having it
+type BrowserIndex struct {
+	source string
+	data   indexFile
+}
+
+func sourceStat(source string) (os.FileInfo, error) {
+	stat, err := os.Stat(source)
+	if err != nil {
+		return nil, err
+	}
+	if !stat.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s: index needs a regular file", source)
+	}
+	return stat, nil
+}
+
+// sourceSum - xxhash of the first and the last sumBlockSize bytes of file
+func sourceSum(file io.ReaderAt, size int64) (uint64, error) {
+	digest := xxhash.New()
+	buf := make([]byte, sumBlockSize)
+	for _, offset := range []int64{0, size - sumBlockSize} {
+		if offset < 0 {
+			offset = 0
+		}
+		n, err := file.ReadAt(buf, offset)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		digest.Write(buf[:n])
+	}
+	return digest.Sum64(), nil
+}
+
+// BuildIndex - reads the whole plain users file at source, malformed lines
+// are not indexed, only remembered
+func BuildIndex(source string) (*BrowserIndex, error) {
+	stat, err := sourceStat(source)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(source)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	sum, err := sourceSum(file, stat.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReaderSize(file, 64<<10)
+	head, err := reader.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if DetectCompression(head) != CompressionNone {
+		return nil, fmt.Errorf("%s: compressed files can not be indexed", source)
+	}
+
+	idx := &BrowserIndex{source: source, data: indexFile{
+		Version:       indexVersion,
+		SourceSize:    stat.Size(),
+		SourceModTime: stat.ModTime().UnixNano(),
+		SourceSum:     sum,
+	}}
+	postings := map[string][]uint32{}
+	// search decides what is malformed and what browsers are by its scanner,
+	// index must agree with it
+	scanner := newUserScanner(nil, nil)
+	scanner.collectAll = true
+	var line, longLine []byte
+	offset := int64(0)
+	for lineNum := uint32(0); err != io.EOF; lineNum++ {
+		line, longLine, err = readLine(reader, longLine)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if err == io.EOF && len(line) == 0 {
+			// source ends with newline
+			break
+		}
+		idx.data.Offsets = append(idx.data.Offsets, offset)
+		offset += int64(len(line))
+
+		if _, _, _, err := scanner.scan(line); err != nil {
+			idx.data.Malformed = append(idx.data.Malformed, lineNum)
+			continue
+		}
+		for i := 0; i < scanner.lineBrowsers(); i++ {
+			browser := scanner.lineBrowser(i)
+			lines := postings[string(browser)]
+			// same browser twice in a user
+			if len(lines) == 0 || lines[len(lines)-1] != lineNum {
+				postings[string(browser)] = append(lines, lineNum)
+			}
+		}
+	}
+
+	for browser := range postings {
+		idx.data.Browsers = append(idx.data.Browsers, browser)
+	}
+	sort.Strings(idx.data.Browsers)
+	for _, browser := range idx.data.Browsers {
+		idx.data.Postings = append(idx.data.Postings, postings[browser])
+	}
+	return idx, nil
+}
+
+// Save - writes index to path, replacing the old one at once
+func (idx *BrowserIndex) Save(path string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	if err := gob.NewEncoder(w).Encode(&idx.data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadIndex - reads index of source from path, ErrStaleIndex if source changed since
+func LoadIndex(path, source string) (*BrowserIndex, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	idx := &BrowserIndex{source: source}
+	if err := gob.NewDecoder(bufio.NewReader(file)).Decode(&idx.data); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if idx.data.Version != indexVersion {
+		return nil, ErrStaleIndex
+	}
+	if err := idx.check(); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// OpenIndex - LoadIndex which builds and saves the index if it is missing,
+// stale or broken
+func OpenIndex(path, source string) (*BrowserIndex, error) {
+	idx, err := LoadIndex(path, source)
+	if err == nil {
+		return idx, nil
+	}
+	if _, statErr := sourceStat(source); statErr != nil {
+		return nil, statErr
+	}
+
+	idx, err = BuildIndex(source)
+	if err != nil {
+		return nil, err
+	}
+	return idx, idx.Save(path)
+}
+
+// check - source is still the one the index was built from
+func (idx *BrowserIndex) check() error {
+	stat, err := sourceStat(idx.source)
+	if err != nil {
+		return err
+	}
+	if stat.Size() != idx.data.SourceSize || stat.ModTime().UnixNano() != idx.data.SourceModTime {
+		return ErrStaleIndex
+	}
+	// size and mtime survive a same size rewrite within mtime granularity or a restored mtime
+	file, err := os.Open(idx.source)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	sum, err := sourceSum(file, stat.Size())
+	if err != nil {
+		return err
+	}
+	if sum != idx.data.SourceSum {
+		return ErrStaleIndex
+	}
+	return nil
+}
+
+// Lines - number of lines in the source
+func (idx *BrowserIndex) Lines() int {
+	return len(idx.data.Offsets)
+}
+
+// needsScan - query may match users none of which browsers matched,
+// e.g. NOT browsers ~ "x" or email ~ "y", index does not help with them
+func (idx *BrowserIndex) needsScan(q *Query) bool {
+	if len(q.fields) > maxIndexFieldMatchers {
+		return true
+	}
+	for set := 0; set < 1<<uint(len(q.fields)); set++ {
+		var matched uint64
+		for i, m := range q.fields {
+			if set&(1<<uint(i)) != 0 {
+				matched |= m.bit()
+			}
+		}
+		if q.root.eval(matched) {
+			return true
+		}
+	}
+	return false
+}
+
+// readLine - line lineNum of the source, buf is reused if it is large enough
+func (idx *BrowserIndex) readLine(file io.ReaderAt, lineNum uint32, buf []byte) (line []byte, offset int64, err error) {
+	end := idx.data.SourceSize
+	if int(lineNum)+1 < len(idx.data.Offsets) {
+		end = idx.data.Offsets[lineNum+1]
+	}
+	offset = idx.data.Offsets[lineNum]
+	if int64(cap(buf)) < end-offset {
+		buf = make([]byte, end-offset)
+	}
+	line = buf[:end-offset]
+	_, err = file.ReadAt(line, offset)
+	return line, offset, err
+}
+
+// Search - FastSearchQuery answered from the index, only lines having matched
+// browsers are read from the source. ErrStaleIndex if source changed.
+func (idx *BrowserIndex) Search(w ResultWriter, q *Query, policy *LinePolicy) error {
+	if err := idx.check(); err != nil {
+		return err
+	}
+	if idx.needsScan(q) {
+		return FastSearchFile(w, idx.source, q, policy)
+	}
+
+	// browser matchers of every line having a matched browser
+	uniqueBrowsers := 0
+	matched := map[uint32]uint64{}
+	for i, browser := range idx.data.Browsers {
+		bits := q.matchBrowser([]byte(browser))
+		if bits == 0 {
+			continue
+		}
+		uniqueBrowsers++
+		for _, lineNum := range idx.data.Postings[i] {
+			matched[lineNum] |= bits
+		}
+	}
+	candidates := make([]uint32, 0, len(matched))
+	for lineNum, bits := range matched {
+		// without email and name matchers browsers decide, no need to read the line
+		if len(q.fields) != 0 || q.root.eval(bits) {
+			candidates = append(candidates, lineNum)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i] < candidates[j] })
+
+	file, err := os.Open(idx.source)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := w.Start(); err != nil {
+		return err
+	}
+	scanner := newUserScanner(q, nil)
+	checker := newLineChecker(policy)
+	malformed := idx.data.Malformed
+	if !checker.checkAll() {
+		malformed = nil
+	}
+	var buf []byte
+	// candidates and malformed lines merged in file order
+	for len(candidates) != 0 || len(malformed) != 0 {
+		var lineNum uint32
+		isMalformed := len(candidates) == 0 || len(malformed) != 0 && malformed[0] <= candidates[0]
+		if isMalformed {
+			lineNum, malformed = malformed[0], malformed[1:]
+			// a line is read once even if the index has it in both lists
+			if len(candidates) != 0 && candidates[0] == lineNum {
+				candidates = candidates[1:]
+			}
+		} else {
+			lineNum, candidates = candidates[0], candidates[1:]
+		}
+		line, offset, err := idx.readLine(file, lineNum, buf)
+		if err != nil {
+			return err
+		}
+		buf = line
+
+		// the scanner has the last word, index is built by the same one
+		// and the source did not change, so they should not disagree
+		name, email, ok, scanErr := scanner.scan(line)
+		if scanErr != nil {
+			if err := checker.reject(int(lineNum), offset, line, scanErr); err != nil {
+				return err
+			}
+			continue
+		}
+		if ok {
+			if err := w.Found(int(lineNum), name, email); err != nil {
+				return err
+			}
+		}
+	}
+	return w.Finish(SearchStats{UniqueBrowsers: uniqueBrowsers, MalformedLines: checker.malformed})
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// copyUsers - data/users.txt copy in a temp dir, to be changed by tests
+func copyUsers(t *testing.T) (dir, source string) {
+	dir, err := ioutil.TempDir("", "hw3")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	source = filepath.Join(dir, "users.txt")
+	if err := ioutil.WriteFile(source, data, 0644); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return dir, source
+}
+
+func TestIndexSearch(t *testing.T) {
+	dir, source := copyUsers(t)
+	defer os.RemoveAll(dir)
+	indexPath := filepath.Join(dir, "users.idx")
+
+	idx, err := OpenIndex(indexPath, source)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if idx.Lines() != 1000 {
+		t.Errorf("expected 1000 lines, got %d", idx.Lines())
+	}
+	if idx, err = LoadIndex(indexPath, source); err != nil {
+		t.Fatalf("saved index does not load: %s", err)
+	}
+
+	for _, src := range []string{
+		DefaultQuery,
+		`browsers =~ "MSIE [6-8]" AND email ~ ".edu"`,
+		`browsers ~ "Opera" OR browsers ~ "Android" AND NOT browsers ~ "Chrome"`,
+		`IE < 9 on Windows OR Chrome >= 40 device mobile`,
+		// need a full scan
+		`NOT browsers ~ "Android"`,
+		`name ~ "Ann" OR browsers ~ "MSIE"`,
+	} {
+		q := MustParseQuery(src)
+		expected, out := new(bytes.Buffer), new(bytes.Buffer)
+		if err := FastSearchFile(NewTextWriter(expected), source, q, nil); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := idx.Search(NewTextWriter(out), q, nil); err != nil {
+			t.Fatalf("%s: unexpected error: %s", src, err)
+		}
+		if out.String() != expected.String() {
+			t.Errorf("%s: results not match\nGot:\n%v\nExpected:\n%v", src, out, expected)
+		}
+	}
+}
+
+func TestIndexInvalidation(t *testing.T) {
+	dir, source := copyUsers(t)
+	defer os.RemoveAll(dir)
+	indexPath := filepath.Join(dir, "users.idx")
+
+	idx, err := OpenIndex(indexPath, source)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	file, err := os.OpenFile(source, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	file.WriteString("\n" + `{"browsers":["Android 1.0","MSIE 10.0"],"email":"new@user.com","name":"New User"}`)
+	file.Close()
+	// same size and coarse mtime would hide the change, it is not the case here
+	os.Chtimes(source, time.Now(), time.Now().Add(time.Second))
+
+	if err := idx.Search(NewTextWriter(ioutil.Discard), defaultQuery, nil); err != ErrStaleIndex {
+		t.Errorf("expected stale index, got %v", err)
+	}
+	if _, err := LoadIndex(indexPath, source); err != ErrStaleIndex {
+		t.Errorf("expected stale index, got %v", err)
+	}
+
+	idx, err = OpenIndex(indexPath, source)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	out := new(bytes.Buffer)
+	if err := idx.Search(NewTextWriter(out), defaultQuery, nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.Contains(out.String(), "[1000] New User <new [at] user.com>") {
+		t.Errorf("rebuilt index misses new user:\n%s", out)
+	}
+
+	ioutil.WriteFile(indexPath, []byte("garbage"), 0644)
+	if _, err := LoadIndex(indexPath, source); err == nil {
+		t.Errorf("expected error for broken index")
+	}
+	if _, err := OpenIndex(indexPath, source); err != nil {
+		t.Errorf("broken index must be rebuilt, got %s", err)
+	}
+}
+
+func TestIndexSameSizeRewrite(t *testing.T) {
+	dir, source := copyUsers(t)
+	defer os.RemoveAll(dir)
+	indexPath := filepath.Join(dir, "users.idx")
+
+	idx, err := OpenIndex(indexPath, source)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	stat, err := os.Stat(source)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	data, err := ioutil.ReadFile(source)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// same size, mtime restored, only the content tells the change
+	data = bytes.Replace(data, []byte("Android"), []byte("Andr0id"), 1)
+	if err := ioutil.WriteFile(source, data, 0644); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	os.Chtimes(source, stat.ModTime(), stat.ModTime())
+
+	if err := idx.Search(NewTextWriter(ioutil.Discard), defaultQuery, nil); err != ErrStaleIndex {
+		t.Errorf("expected stale index, got %v", err)
+	}
+	if _, err := LoadIndex(indexPath, source); err != ErrStaleIndex {
+		t.Errorf("expected stale index, got %v", err)
+	}
+}
+
+func TestIndexScannerDisagreement(t *testing.T) {
+	dir, source := copyUsers(t)
+	defer os.RemoveAll(dir)
+
+	idx, err := BuildIndex(source)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := new(bytes.Buffer)
+	if err := idx.Search(NewTextWriter(expected), defaultQuery, &LinePolicy{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// valid line the index takes for malformed is searched, not reported as stale
+	idx.data.Malformed = []uint32{0, 1, 2}
+	out := new(bytes.Buffer)
+	if err := idx.Search(NewTextWriter(out), defaultQuery, &LinePolicy{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if out.String() != expected.String() {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", out, expected)
+	}
+}
+
+func TestRunIndexCommand(t *testing.T) {
+	dir, source := copyUsers(t)
+	defer os.RemoveAll(dir)
+
+	out := new(bytes.Buffer)
+	if err := run([]string{"index", source}, out); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.Contains(out.String(), "indexed 1000 lines") {
+		t.Errorf("unexpected output %q", out)
+	}
+
+	expected := new(bytes.Buffer)
+	SlowSearch(expected)
+	for _, args := range [][]string{
+		{"search", "-index", source + ".idx", source},
+		{"search", "-workers", "0", source},
+		{"search", source},
+	} {
+		out.Reset()
+		if err := run(args, out); err != nil {
+			t.Fatalf("%v: unexpected error: %s", args, err)
+		}
+		if out.String() != expected.String() {
+			t.Errorf("%v: results not match\nGot:\n%v\nExpected:\n%v", args, out, expected)
+		}
+	}
+
+	for _, args := range [][]string{
+		{},
+		{"grep"},
+		{"search", "-query", "browsers"},
+		{"search", "a", "b"},
+		{"index", filepath.Join(dir, "missing.txt")},
+	} {
+		if err := run(args, ioutil.Discard); err == nil {
+			t.Errorf("%v: expected error", args)
+		}
+	}
+}
+
+func BenchmarkIndex(b *testing.B) {
+	idx, err := BuildIndex(filePath)
+	if err != nil {
+		b.Fatalf("unexpected error: %s", err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		idx.Search(NewTextWriter(ioutil.Discard), defaultQuery, nil)
+	}
+}
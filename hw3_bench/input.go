@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Compression - format of users dump
+type Compression int
+
+const (
+	CompressionNone Compression = iota
+	CompressionGzip
+	CompressionZstd
+)
+
+// DetectCompression - format by the magic bytes at the start of a dump
+func DetectCompression(head []byte) Compression {
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		return CompressionGzip
+	case bytes.HasPrefix(head, zstdMagic):
+		return CompressionZstd
+	default:
+		return CompressionNone
+	}
+}
+
+type inputReader struct {
+	io.Reader
+	closers []func() error
+}
+
+func (ir *inputReader) Close() error {
+	var err error
+	for i := len(ir.closers) - 1; i >= 0; i-- {
+		if closeErr := ir.closers[i](); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// NewInputReader - reads users dump from r, gzip and zstd are decompressed on the fly.
+// Close does not close r.
+func NewInputReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReaderSize(r, 64<<10)
+	head, err := br.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch DetectCompression(head) {
+	case CompressionGzip:
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return &inputReader{Reader: gz, closers: []func() error{gz.Close}}, nil
+	case CompressionZstd:
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return &inputReader{Reader: zr, closers: []func() error{func() error {
+			zr.Close()
+			return nil
+		}}}, nil
+	default:
+		return &inputReader{Reader: br}, nil
+	}
+}
+
+// OpenInput - opens users dump at path, see NewInputReader
+func OpenInput(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewInputReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	ir := r.(*inputReader)
+	ir.closers = append([]func() error{file.Close}, ir.closers...)
+	return ir, nil
+}
+
+// readLine - next line of r without copying when it fits reader buffer,
+// longer lines are collected in buf. Line is valid until next read.
+func readLine(r *bufio.Reader, buf []byte) (line, newBuf []byte, err error) {
+	line, err = r.ReadSlice('\n')
+	if err != bufio.ErrBufferFull {
+		return line, buf, err
+	}
+	buf = append(buf[:0], line...)
+	for err == bufio.ErrBufferFull {
+		line, err = r.ReadSlice('\n')
+		buf = append(buf, line...)
+	}
+	return buf, buf, err
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// writeCompressedDumps - data/users.txt as gzip and zstd files in dir
+func writeCompressedDumps(t *testing.T, dir string) map[string]string {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	gzBuf := new(bytes.Buffer)
+	gz := gzip.NewWriter(gzBuf)
+	gz.Write(data)
+	gz.Close()
+
+	zstdBuf := new(bytes.Buffer)
+	zw, err := zstd.NewWriter(zstdBuf)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	zw.Write(data)
+	zw.Close()
+
+	paths := map[string]string{
+		"gzip": filepath.Join(dir, "users.txt.gz"),
+		"zstd": filepath.Join(dir, "users.txt.zst"),
+	}
+	if err := ioutil.WriteFile(paths["gzip"], gzBuf.Bytes(), 0644); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := ioutil.WriteFile(paths["zstd"], zstdBuf.Bytes(), 0644); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return paths
+}
+
+func TestCompressedInput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hw3")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	expected := new(bytes.Buffer)
+	SlowSearch(expected)
+
+	for format, path := range writeCompressedDumps(t, dir) {
+		out := new(bytes.Buffer)
+		if err := FastSearchFile(NewTextWriter(out), path, defaultQuery, nil); err != nil {
+			t.Fatalf("%s: unexpected error: %s", format, err)
+		}
+		if out.String() != expected.String() {
+			t.Errorf("%s: results not match\nGot:\n%v\nExpected:\n%v", format, out, expected)
+		}
+
+		out.Reset()
+		if err := ParallelSearch(NewTextWriter(out), path, defaultQuery, 4, nil); err != nil || out.String() != expected.String() {
+			t.Errorf("%s: parallel search failed: %v", format, err)
+		}
+
+		r, err := OpenInput(path)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", format, err)
+		}
+		out.Reset()
+		if err := SlowSearchReader(out, r, nil); err != nil || out.String() != expected.String() {
+			t.Errorf("%s: slow search failed: %v", format, err)
+		}
+		r.Close()
+	}
+}
+
+func TestSearchErrors(t *testing.T) {
+	if err := FastSearchFile(NewTextWriter(ioutil.Discard), "./data/missing.txt", defaultQuery, nil); !os.IsNotExist(err) {
+		t.Errorf("expected not exist error, got %v", err)
+	}
+	if err := ParallelSearch(NewTextWriter(ioutil.Discard), "./data/missing.txt", defaultQuery, 1, nil); !os.IsNotExist(err) {
+		t.Errorf("expected not exist error, got %v", err)
+	}
+	if err := SlowSearchReader(ioutil.Discard, strings.NewReader("{broken"), &LinePolicy{Strict: true}); err == nil {
+		t.Errorf("expected error for malformed line")
+	}
+	// truncated gzip stream
+	if _, err := NewInputReader(bytes.NewReader(gzipMagic)); err == nil {
+		t.Errorf("expected error for broken gzip header")
+	}
+}
+
+func TestDetectCompression(t *testing.T) {
+	cases := map[string]Compression{
+		"":                         CompressionNone,
+		`{"browsers":[]}`:          CompressionNone,
+		"\x1f\x8b\x08\x00":         CompressionGzip,
+		"\x28\xb5\x2f\xfd\x00\x58": CompressionZstd,
+	}
+	for head, expected := range cases {
+		if c := DetectCompression([]byte(head)); c != expected {
+			t.Errorf("%q: expected %d, got %d", head, expected, c)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// LinePolicy - what search does with lines which are not valid users JSON.
+// nil policy keeps FastSearch behaviour: malformed lines silently do not match
+// and lines which can not match are not even parsed.
+type LinePolicy struct {
+	// Strict - first malformed line fails the search with *LineError,
+	// otherwise malformed lines are skipped and counted
+	Strict bool
+	// Quarantine - malformed lines are copied here, one per line
+	Quarantine io.Writer
+}
+
+// LineError - malformed line of users dump
+type LineError struct {
+	// Line - 1-based line number, results index is Line-1
+	Line int
+	// Offset - offset of the line start in the dump
+	Offset int64
+	Err    error
+}
+
+func (le *LineError) Error() string {
+	return fmt.Sprintf("line %d at byte %d: %s", le.Line, le.Offset, le.Err)
+}
+
+// SearchStats - totals reported after the search
+type SearchStats struct {
+	UniqueBrowsers int
+	// MalformedLines - lines skipped by lenient LinePolicy
+	MalformedLines int
+}
+
+// lineChecker - applies LinePolicy during one search
+type lineChecker struct {
+	policy    *LinePolicy
+	malformed int
+}
+
+func newLineChecker(policy *LinePolicy) *lineChecker {
+	return &lineChecker{policy: policy}
+}
+
+// checkAll - every line has to be parsed to be checked
+func (lc *lineChecker) checkAll() bool {
+	return lc.policy != nil
+}
+
+// reject - handles malformed line idx (0-based), error means the search must stop
+func (lc *lineChecker) reject(idx int, offset int64, line []byte, err error) error {
+	if lc.policy == nil {
+		return nil
+	}
+	lc.malformed++
+	if lc.policy.Quarantine != nil {
+		if _, err := lc.policy.Quarantine.Write(append(bytes.TrimRight(line, "\r\n"), '\n')); err != nil {
+			return err
+		}
+	}
+	if lc.policy.Strict {
+		return &LineError{Line: idx + 1, Offset: offset, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var malformedLines = map[int]string{
+	3:   `{"browsers":["Android","MSIE"],"email":"broken@a.com","name":"Broken"`,
+	500: `not a json at all`,
+	998: `{"browsers":["Android 9","MSIE 5"],"email":"x@y.z","name":"Trailing"} garbage`,
+}
+
+// writeMalformedUsers - data/users.txt with some of its lines replaced by malformed ones
+func writeMalformedUsers(t *testing.T, dir string) (path string, offsets map[int]int64) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	lines := strings.Split(string(data), "\n")
+	offsets = map[int]int64{}
+	offset := int64(0)
+	for i := range lines {
+		if line, ok := malformedLines[i]; ok {
+			lines[i] = line
+			offsets[i] = offset
+		}
+		offset += int64(len(lines[i])) + 1
+	}
+	path = filepath.Join(dir, "users.txt")
+	if err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return path, offsets
+}
+
+// searchFuncs - every search over a file with given policy
+func searchFuncs(dir string) map[string]func(w ResultWriter, path string, q *Query, policy *LinePolicy) error {
+	return map[string]func(w ResultWriter, path string, q *Query, policy *LinePolicy) error{
+		"fast": FastSearchFile,
+		"parallel": func(w ResultWriter, path string, q *Query, policy *LinePolicy) error {
+			return ParallelSearch(w, path, q, 4, policy)
+		},
+		"index": func(w ResultWriter, path string, q *Query, policy *LinePolicy) error {
+			idx, err := OpenIndex(filepath.Join(dir, "users.idx"), path)
+			if err != nil {
+				return err
+			}
+			return idx.Search(w, q, policy)
+		},
+	}
+}
+
+func TestLenientLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hw3")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path, _ := writeMalformedUsers(t, dir)
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected, expectedQuarantine := new(bytes.Buffer), new(bytes.Buffer)
+	err = SlowSearchReader(expected, file, &LinePolicy{Quarantine: expectedQuarantine})
+	file.Close()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.HasSuffix(expected.String(), "Malformed lines 3\n") || strings.Contains(expected.String(), "Broken") {
+		t.Fatalf("unexpected slow output:\n%s", expected)
+	}
+	if expectedQuarantine.String() != malformedLines[3]+"\n"+malformedLines[500]+"\n"+malformedLines[998]+"\n" {
+		t.Errorf("unexpected quarantine:\n%s", expectedQuarantine)
+	}
+
+	for name, search := range searchFuncs(dir) {
+		out, quarantine := new(bytes.Buffer), new(bytes.Buffer)
+		if err := search(NewTextWriter(out), path, defaultQuery, &LinePolicy{Quarantine: quarantine}); err != nil {
+			t.Fatalf("%s: unexpected error: %s", name, err)
+		}
+		if out.String() != expected.String() {
+			t.Errorf("%s: results not match\nGot:\n%v\nExpected:\n%v", name, out, expected)
+		}
+		if quarantine.String() != expectedQuarantine.String() {
+			t.Errorf("%s: quarantine not match\nGot:\n%v\nExpected:\n%v", name, quarantine, expectedQuarantine)
+		}
+
+		// nil policy neither fails nor counts
+		out.Reset()
+		if err := search(NewTextWriter(out), path, defaultQuery, nil); err != nil {
+			t.Fatalf("%s: unexpected error: %s", name, err)
+		}
+		if strings.Contains(out.String(), "Malformed") {
+			t.Errorf("%s: nil policy must not count malformed lines", name)
+		}
+	}
+}
+
+func TestStrictLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hw3")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path, offsets := writeMalformedUsers(t, dir)
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer file.Close()
+	results := map[string]error{
+		"slow": SlowSearchReader(ioutil.Discard, file, &LinePolicy{Strict: true}),
+	}
+	for name, search := range searchFuncs(dir) {
+		results[name] = search(NewTextWriter(ioutil.Discard), path, defaultQuery, &LinePolicy{Strict: true})
+	}
+
+	for name, err := range results {
+		lineErr, ok := err.(*LineError)
+		if !ok {
+			t.Errorf("%s: expected *LineError, got %v", name, err)
+			continue
+		}
+		if lineErr.Line != 4 || lineErr.Offset != offsets[3] || lineErr.Err == nil {
+			t.Errorf("%s: expected line 4 at byte %d, got %s", name, offsets[3], lineErr)
+		}
+	}
+}
+
+func TestRunMalformedFlags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hw3")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path, _ := writeMalformedUsers(t, dir)
+	quarantinePath := filepath.Join(dir, "quarantine.txt")
+
+	out := new(bytes.Buffer)
+	if err := run([]string{"search", "-format", "summary", "-quarantine", quarantinePath, path}, out); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.Contains(out.String(), `"malformed_lines":3`) {
+		t.Errorf("unexpected output %s", out)
+	}
+	if quarantine, _ := ioutil.ReadFile(quarantinePath); strings.Count(string(quarantine), "\n") != 3 {
+		t.Errorf("unexpected quarantine %q", quarantine)
+	}
+
+	// by default lines which can not match are skipped unparsed, so nothing is counted
+	for _, c := range []struct {
+		args    []string
+		counted bool
+	}{
+		{[]string{"search", "-format", "summary", path}, false},
+		{[]string{"search", "-format", "summary", "-malformed", "lenient", path}, true},
+	} {
+		out.Reset()
+		if err := run(c.args, out); err != nil {
+			t.Fatalf("%v: unexpected error: %s", c.args, err)
+		}
+		if strings.Contains(out.String(), `"malformed_lines":3`) != c.counted {
+			t.Errorf("%v: unexpected output %s", c.args, out)
+		}
+	}
+
+	if err := run([]string{"search", "-malformed", "strict", path}, ioutil.Discard); err == nil || !strings.Contains(err.Error(), "line 4 at byte") {
+		t.Errorf("expected line error, got %v", err)
+	}
+	for _, args := range [][]string{
+		{"search", "-malformed", "panic", path},
+		{"search", "-malformed", "ignore", "-quarantine", quarantinePath, path},
+	} {
+		if err := run(args, ioutil.Discard); err == nil {
+			t.Errorf("%v: expected error", args)
+		}
+	}
+}
+
+func TestSlowSearchMissingFields(t *testing.T) {
+	// valid users without name or email, fast search prints them empty
+	for _, src := range []string{
+		``,
+		`{"browsers":["Android 4","MSIE 8"],"email":"ann@a.com"}`,
+		`{"browsers":["Android 4","MSIE 8"],"name":null,"email":null}` + "\n",
+	} {
+		expected, out := new(bytes.Buffer), new(bytes.Buffer)
+		if err := FastSearchQuery(NewTextWriter(expected), strings.NewReader(src), defaultQuery, nil); err != nil {
+			t.Fatalf("%q: unexpected error: %s", src, err)
+		}
+		if err := SlowSearchReader(out, strings.NewReader(src), nil); err != nil {
+			t.Fatalf("%q: unexpected error: %s", src, err)
+		}
+		if out.String() != expected.String() {
+			t.Errorf("%q: results not match\nGot:\n%v\nExpected:\n%v", src, out, expected)
+		}
+	}
+}
+
+// trickyLines - lines the reference decoding into a map accepts although they
+// look odd, followed by ones it rejects although they look almost right
+var trickyLines = []string{
+	`{"browsers":["Android 6","MSIE 7"],"email":"valid@a.com","name":"Valid"}`,
+	`{"browsers":["Android 6",7,"MSIE 7"],"email":123,"name":"Number"}`,
+	`{"browsers":"Android MSIE","email":"string@a.com","name":"String"}`,
+	`null`,
+	`{"browsers":["Android 1"],"browsers":["MSIE 8","Android 8"],"name":"Repeated","name":null}`,
+	`{"n\u0061me":"Escaped","browsers":["Android 9","MSIE 9"],"x":{"a":[1,{"b":null}],"c":-0.5e+3}}`,
+	`{"browsers":["Android 6","MSIE 7"],"email":"bare@a.com","name":"Bare","x":nul}`,
+	`{"browsers":["Android 6","MSIE 7"],"email":"bare@a.com","name":"Bare","x":tru}`,
+	`{"browsers":["Android 6","MSIE 7"],"email":"bare@a.com","name":"Bare","x":abc}`,
+	`{"browsers":["Android 6","MSIE 7"],"email":"escape@a.com","name":"Escape","x":"\q"}`,
+	`{"browsers":["Android 6","MSIE 7"],"email":"commas@a.com","name":"Commas","x":[1,,2]}`,
+	`{"browsers":["Android 6","MSIE 7"],"email":"colon@a.com","name":"Colon","x":{"a" 1}}`,
+	`{"browsers":["Android 6","MSIE 7"],"email":"range@a.com","name":"Range","x":1e400}`,
+}
+
+func TestTrickyLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hw3")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "users.txt")
+	data := strings.Join(trickyLines, "\n") + "\n"
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := new(bytes.Buffer)
+	if err := SlowSearchReader(expected, strings.NewReader(data), nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.Contains(expected.String(), "[1] Number <>\n[4]  <>\n[5] Escaped <>\n\n") {
+		t.Fatalf("unexpected slow output:\n%s", expected)
+	}
+	for name, search := range searchFuncs(dir) {
+		out := new(bytes.Buffer)
+		if err := search(NewTextWriter(out), path, defaultQuery, nil); err != nil {
+			t.Fatalf("%s: unexpected error: %s", name, err)
+		}
+		if out.String() != expected.String() {
+			t.Errorf("%s: results not match\nGot:\n%v\nExpected:\n%v", name, out, expected)
+		}
+	}
+
+	// lenient policy flags and quarantines the same lines as the reference
+	expected.Reset()
+	expectedQuarantine := new(bytes.Buffer)
+	if err := SlowSearchReader(expected, strings.NewReader(data), &LinePolicy{Quarantine: expectedQuarantine}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.HasSuffix(expected.String(), "Malformed lines 7\n") {
+		t.Fatalf("unexpected slow output:\n%s", expected)
+	}
+	for name, search := range searchFuncs(dir) {
+		out, quarantine := new(bytes.Buffer), new(bytes.Buffer)
+		if err := search(NewTextWriter(out), path, defaultQuery, &LinePolicy{Quarantine: quarantine}); err != nil {
+			t.Fatalf("%s: unexpected error: %s", name, err)
+		}
+		if out.String() != expected.String() {
+			t.Errorf("%s: results not match\nGot:\n%v\nExpected:\n%v", name, out, expected)
+		}
+		if quarantine.String() != expectedQuarantine.String() {
+			t.Errorf("%s: quarantine not match\nGot:\n%v\nExpected:\n%v", name, quarantine, expectedQuarantine)
+		}
+	}
+
+	// strict one fails on the same line, results written before it may differ:
+	// the reference writes nothing until the end
+	expectedErr := SlowSearchReader(ioutil.Discard, strings.NewReader(data), &LinePolicy{Strict: true})
+	lineErr, ok := expectedErr.(*LineError)
+	if !ok || lineErr.Line != 7 {
+		t.Fatalf("expected slow error at line 7, got %v", expectedErr)
+	}
+	for name, search := range searchFuncs(dir) {
+		err := search(NewTextWriter(ioutil.Discard), path, defaultQuery, &LinePolicy{Strict: true})
+		if got, ok := err.(*LineError); !ok || got.Line != lineErr.Line || got.Offset != lineErr.Offset {
+			t.Errorf("%s: expected %v, got %v", name, expectedErr, err)
+		}
+	}
+}
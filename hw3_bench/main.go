@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// usage:
+//
+//	hw3 search [-query q] [-workers n] [-index file] [-format text|csv|jsonl|summary] [-email none|at|hash|mask]
+//		[-malformed strict|lenient|ignore] [-quarantine file] [-follow [-from-end] [-poll d]] [users.txt]
+//	hw3 index [-o file] [users.txt]
+//	hw3 stats [-top n] [-approx] [-query q] [-format text|json] [users.txt]
+//	hw3 serve [-addr host:port] [users.txt]
+//	hw3 gen [-users n] [-seed s] [-browsers n] [-max-browsers n] [-skew z] [-edge share] [-o file]
+//
+// index is saved next to users file as users.txt.idx by default
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: hw3 search|index|stats|serve|gen [flags] [users file]")
+	}
+	switch args[0] {
+	case "search":
+		return runSearch(args[1:], stdout)
+	case "index":
+		return runIndex(args[1:], stdout)
+	case "stats":
+		return runStats(args[1:], stdout)
+	case "serve":
+		return runServe(args[1:], stdout)
+	case "gen":
+		return runGen(args[1:], stdout)
+	default:
+		return fmt.Errorf("unknown command %s", args[0])
+	}
+}
+
+// sourceArg - users file from positional arguments
+func sourceArg(flags *flag.FlagSet) (string, error) {
+	switch flags.NArg() {
+	case 0:
+		return filePath, nil
+	case 1:
+		return flags.Arg(0), nil
+	default:
+		return "", fmt.Errorf("expected one users file, got %d", flags.NArg())
+	}
+}
+
+func runSearch(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("search", flag.ContinueOnError)
+	query := flags.String("query", DefaultQuery, "users to look for")
+	workers := flags.Int("workers", 1, "goroutines parsing the file, 0 - all CPUs")
+	indexPath := flags.String("index", "", "browser index to answer from, built if missing or stale")
+	formatName := flags.String("format", string(FormatText), "output format: text, csv, jsonl or summary")
+	emailName := flags.String("email", string(EmailAt), "email obfuscation: none, at, hash or mask")
+	malformed := flags.String("malformed", "", "malformed lines: strict - fail, lenient - skip and count, ignore - skip silently (default, lenient with quarantine)")
+	quarantinePath := flags.String("quarantine", "", "file to copy malformed lines to")
+	follow := flags.Bool("follow", false, "keep searching lines appended to the file until interrupted")
+	fromEnd := flags.Bool("from-end", false, "follow new lines only")
+	poll := flags.Duration("poll", defaultFollowPoll, "how often followed file is checked")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	format, err := ParseOutputFormat(*formatName)
+	if err != nil {
+		return err
+	}
+	email, err := ParseEmailPolicy(*emailName)
+	if err != nil {
+		return err
+	}
+	source, err := sourceArg(flags)
+	if err != nil {
+		return err
+	}
+	w := NewResultWriter(stdout, format, email)
+	// lenient and strict parse every line, ignore keeps lines which can not match unparsed
+	if *malformed == "" {
+		*malformed = "ignore"
+		if *quarantinePath != "" {
+			*malformed = "lenient"
+		}
+	}
+	var policy *LinePolicy
+	switch *malformed {
+	case "strict":
+		policy = &LinePolicy{Strict: true}
+	case "lenient":
+		policy = &LinePolicy{}
+	case "ignore":
+		if *quarantinePath != "" {
+			return fmt.Errorf("quarantine needs strict or lenient malformed lines policy")
+		}
+	default:
+		return fmt.Errorf("unknown malformed lines policy %s", *malformed)
+	}
+	if *quarantinePath != "" {
+		quarantine, err := os.Create(*quarantinePath)
+		if err != nil {
+			return err
+		}
+		defer quarantine.Close()
+		policy.Quarantine = quarantine
+	}
+	q, err := ParseQuery(*query)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case *follow:
+		if *indexPath != "" || *workers != 1 {
+			return fmt.Errorf("follow does not work with index or workers")
+		}
+		// results go to stdout as they are found, running totals to stderr
+		stop := make(chan struct{})
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(interrupt)
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-interrupt:
+				close(stop)
+			case <-done:
+			}
+		}()
+		return Follow(w, source, q, FollowOptions{
+			FromEnd: *fromEnd,
+			Poll:    *poll,
+			Stop:    stop,
+			Policy:  policy,
+			Progress: func(stats SearchStats) {
+				fmt.Fprintln(os.Stderr, "unique browsers", stats.UniqueBrowsers, "malformed lines", stats.MalformedLines)
+			},
+		})
+	case *indexPath != "":
+		idx, err := OpenIndex(*indexPath, source)
+		if err != nil {
+			return err
+		}
+		return idx.Search(w, q, policy)
+	case *workers == 1:
+		return FastSearchFile(w, source, q, policy)
+	default:
+		return ParallelSearch(w, source, q, *workers, policy)
+	}
+}
+
+func runIndex(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("index", flag.ContinueOnError)
+	indexPath := flags.String("o", "", "index file, users file name with .idx if empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	source, err := sourceArg(flags)
+	if err != nil {
+		return err
+	}
+	if *indexPath == "" {
+		*indexPath = source + ".idx"
+	}
+
+	idx, err := BuildIndex(source)
+	if err != nil {
+		return err
+	}
+	if err := idx.Save(*indexPath); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "indexed %d lines, %d browsers into %s\n", idx.Lines(), len(idx.data.Browsers), *indexPath)
+	return nil
+}
+
+func runStats(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("stats", flag.ContinueOnError)
+	topN := flags.Int("top", 10, "number of most frequent browsers")
+	approx := flags.Bool("approx", false, "constant memory estimates for huge dumps")
+	query := flags.String("query", "", "count browsers of matching users only, everyone if empty")
+	format := flags.String("format", "text", "output format: text or json")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unknown format %s", *format)
+	}
+	source, err := sourceArg(flags)
+	if err != nil {
+		return err
+	}
+
+	opts := StatsOptions{TopN: *topN, Approximate: *approx, Policy: &LinePolicy{}}
+	if *query != "" {
+		if opts.Query, err = ParseQuery(*query); err != nil {
+			return err
+		}
+	}
+	report, err := BrowserStatsFile(source, opts)
+	if err != nil {
+		return err
+	}
+	if *format == "json" {
+		return json.NewEncoder(stdout).Encode(report)
+	}
+	return report.WriteText(stdout)
+}
+
+func runServe(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := flags.String("addr", "localhost:8080", "address to listen on")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	source, err := sourceArg(flags)
+	if err != nil {
+		return err
+	}
+
+	srv, err := NewSearchServer(source)
+	if err != nil {
+		return err
+	}
+	info := srv.dataset().Info()
+	fmt.Fprintf(stdout, "loaded %d users of %s, listening on %s\n", info.Users, source, *addr)
+	return http.ListenAndServe(*addr, srv)
+}
+
+func runGen(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("gen", flag.ContinueOnError)
+	opts := GeneratorOptions{}
+	flags.IntVar(&opts.Users, "users", 1000, "number of users")
+	flags.Int64Var(&opts.Seed, "seed", 1, "same seed gives the same dump")
+	flags.IntVar(&opts.Browsers, "browsers", 200, "size of the browsers pool")
+	flags.IntVar(&opts.MaxBrowsers, "max-browsers", 6, "max browsers of a user")
+	flags.Float64Var(&opts.Skew, "skew", 0, "Zipf exponent of browsers popularity, uniform if up to 1")
+	flags.Float64Var(&opts.EdgeCases, "edge", 0, "share of users with edge cases")
+	output := flags.String("o", "", "users file, stdout if empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return fmt.Errorf("unexpected arguments %v", flags.Args())
+	}
+	if *output == "" {
+		return GenerateUsers(stdout, opts)
+	}
+
+	file, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := GenerateUsers(file, opts); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
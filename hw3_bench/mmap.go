@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"runtime/debug"
+	"unsafe"
+)
+
+// mmapThreshold - smaller files are read faster than mapped
+var mmapThreshold int64 = 4 << 20
+
+// mapInput - whole file mapped read-only if it is a large plain regular file
+// and the platform supports it, nil otherwise. Reading past the end of a file
+// truncated while it is mapped faults, see searchMemory.
+func mapInput(file *os.File) []byte {
+	stat, err := file.Stat()
+	if err != nil || !stat.Mode().IsRegular() || stat.Size() < mmapThreshold || int64(int(stat.Size())) != stat.Size() {
+		return nil
+	}
+	data, err := mmap(file, int(stat.Size()))
+	if err != nil {
+		// buffered reading works everywhere
+		return nil
+	}
+	if DetectCompression(data) != CompressionNone {
+		munmap(data)
+		return nil
+	}
+	return data
+}
+
+// searchMapped - FastSearchQuery over the dump in memory, lines are not copied.
+// If file shrinks meanwhile the search goes on reading it as usual.
+func searchMapped(w ResultWriter, file *os.File, data []byte, q *Query, policy *LinePolicy) error {
+	ls := newLineSearch(w, q, policy)
+	if err := w.Start(); err != nil {
+		return err
+	}
+	cnt, offset, faulted, err := ls.searchMemory(data)
+	if err != nil {
+		return err
+	}
+	if faulted {
+		// pages past the new end are gone, file tells what is left of it
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		if err := ls.searchLines(file, cnt, offset); err != nil {
+			return err
+		}
+	}
+	return w.Finish(ls.stats())
+}
+
+// searchMemory - searches lines of data until its end or a fault, cnt and
+// offset - the line a fault happened on. Pages of a mapped file past its end
+// raise SIGBUS, here it is a panic which is recovered, results of the line
+// are not written yet then.
+func (ls *lineSearch) searchMemory(data []byte) (cnt int, offset int64, faulted bool, err error) {
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if r := recover(); r != nil {
+			if !isFaultIn(r, data) {
+				panic(r)
+			}
+			faulted = true
+		}
+	}()
+	for offset < int64(len(data)) {
+		end := int64(len(data))
+		if newline := bytes.IndexByte(data[offset:], '\n'); newline >= 0 {
+			end = offset + int64(newline) + 1
+		}
+		if err := ls.search(cnt, offset, data[offset:end]); err != nil {
+			return cnt, offset, false, err
+		}
+		cnt, offset = cnt+1, end
+	}
+	return cnt, offset, false, nil
+}
+
+// isFaultIn - panic value r is a memory fault inside of data
+func isFaultIn(r interface{}, data []byte) bool {
+	fault, ok := r.(interface{ Addr() uintptr })
+	if !ok || len(data) == 0 {
+		return false
+	}
+	start := uintptr(unsafe.Pointer(&data[0]))
+	return fault.Addr() >= start && fault.Addr()-start < uintptr(len(data))
+}
@@ -0,0 +1,23 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+func mmap(file *os.File, size int) ([]byte, error) {
+	data, err := syscall.Mmap(int(file.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	// lines are read once from start to end
+	syscall.Madvise(data, syscall.MADV_SEQUENTIAL)
+	return data, nil
+}
+
+func munmap(data []byte) error {
+	return syscall.Munmap(data)
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+var errMmapUnsupported = errors.New("mmap is not supported on this platform")
+
+func mmap(file *os.File, size int) ([]byte, error) {
+	return nil, errMmapUnsupported
+}
+
+func munmap(data []byte) error {
+	return errMmapUnsupported
+}
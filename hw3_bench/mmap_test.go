@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// withMmapThreshold - runs f with every non-empty file mapped
+func withMmapThreshold(threshold int64, f func()) {
+	saved := mmapThreshold
+	mmapThreshold = threshold
+	defer func() {
+		mmapThreshold = saved
+	}()
+	f()
+}
+
+func TestMapInput(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("mmap backend is linux only")
+	}
+	dir, err := ioutil.TempDir("", "hw3")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	compressed := writeCompressedDumps(t, dir)
+	empty := filepath.Join(dir, "empty.txt")
+	ioutil.WriteFile(empty, nil, 0644)
+
+	withMmapThreshold(1, func() {
+		for path, mapped := range map[string]bool{
+			filePath:           true,
+			compressed["gzip"]: false,
+			compressed["zstd"]: false,
+			empty:              false,
+		} {
+			file, err := os.Open(path)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			data := mapInput(file)
+			if (data != nil) != mapped {
+				t.Errorf("%s: expected mapped %v", path, mapped)
+			}
+			if data != nil {
+				munmap(data)
+			}
+			file.Close()
+		}
+	})
+
+	// small files are read
+	file, err := os.Open(filePath)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer file.Close()
+	if data := mapInput(file); data != nil {
+		t.Errorf("file below threshold is mapped")
+		munmap(data)
+	}
+}
+
+func TestMappedSearch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hw3")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	malformed, _ := writeMalformedUsers(t, dir)
+
+	for _, path := range []string{filePath, malformed} {
+		for _, policy := range []*LinePolicy{nil, {}, {Strict: true}} {
+			file, err := os.Open(path)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			expected := new(bytes.Buffer)
+			expectedErr := FastSearchQuery(NewTextWriter(expected), file, defaultQuery, policy)
+			file.Close()
+
+			withMmapThreshold(1, func() {
+				out := new(bytes.Buffer)
+				err := FastSearchFile(NewTextWriter(out), path, defaultQuery, policy)
+				if !reflect.DeepEqual(err, expectedErr) {
+					t.Errorf("%s %+v: expected error %v, got %v", path, policy, expectedErr, err)
+				}
+				if out.String() != expected.String() {
+					t.Errorf("%s %+v: results not match\nGot:\n%v\nExpected:\n%v", path, policy, out, expected)
+				}
+			})
+		}
+	}
+}
+
+// truncatingWriter - truncates the file being searched once the first user is found
+type truncatingWriter struct {
+	ResultWriter
+	path  string
+	found int
+}
+
+func (tw *truncatingWriter) Found(idx int, name, email []byte) error {
+	tw.found++
+	if tw.found == 1 {
+		if err := os.Truncate(tw.path, 0); err != nil {
+			return err
+		}
+	}
+	return tw.ResultWriter.Found(idx, name, email)
+}
+
+func TestMappedSearchTruncated(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("mmap backend is linux only")
+	}
+	dir, source := copyUsers(t)
+	defer os.RemoveAll(dir)
+
+	withMmapThreshold(1, func() {
+		// pages of the mapping are gone, reading them faults instead of killing the process
+		out := new(bytes.Buffer)
+		w := &truncatingWriter{ResultWriter: NewTextWriter(out), path: source}
+		if err := FastSearchFile(w, source, defaultQuery, &LinePolicy{Strict: true}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if w.found != 1 || !strings.Contains(out.String(), "Total unique browsers") {
+			t.Errorf("expected the only user found before truncation, got %d:\n%s", w.found, out)
+		}
+	})
+}
+
+// BenchmarkReadBackends - FastSearchFile reading files through bufio and mmap
+func BenchmarkReadBackends(b *testing.B) {
+	dir, err := ioutil.TempDir("", "hw3")
+	if err != nil {
+		b.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	generated := filepath.Join(dir, "users.txt")
+	file, err := os.Create(generated)
+	if err != nil {
+		b.Fatalf("unexpected error: %s", err)
+	}
+	GenerateUsers(file, GeneratorOptions{Seed: 1, Users: 100000, Skew: 1.1})
+	file.Close()
+
+	for name, path := range map[string]string{"small": filePath, "generated": generated} {
+		stat, err := os.Stat(path)
+		if err != nil {
+			b.Fatalf("unexpected error: %s", err)
+		}
+		for backend, threshold := range map[string]int64{"buffered": stat.Size() + 1, "mmap": 1} {
+			b.Run(name+"/"+backend, func(b *testing.B) {
+				withMmapThreshold(threshold, func() {
+					b.SetBytes(stat.Size())
+					b.ReportAllocs()
+					for i := 0; i < b.N; i++ {
+						FastSearchFile(NewTextWriter(ioutil.Discard), path, defaultQuery, nil)
+					}
+				})
+			})
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"unicode/utf8"
+)
+
+// OutputFormat - how search results are written
+type OutputFormat string
+
+const (
+	// FormatText - "found users:" list of SlowSearch
+	FormatText OutputFormat = "text"
+	// FormatCSV - index,name,email rows with a header
+	FormatCSV OutputFormat = "csv"
+	// FormatJSONLines - {"index":..,"name":..,"email":..} object per line
+	FormatJSONLines OutputFormat = "jsonl"
+	// FormatSummary - single JSON document with counts only
+	FormatSummary OutputFormat = "summary"
+)
+
+// EmailPolicy - how emails are obfuscated in search results
+type EmailPolicy string
+
+const (
+	// EmailNone - email as is
+	EmailNone EmailPolicy = "none"
+	// EmailAt - user [at] domain, as SlowSearch does
+	EmailAt EmailPolicy = "at"
+	// EmailHash - hex sha256 of email, same emails give same hashes
+	EmailHash EmailPolicy = "hash"
+	// EmailMask - first letter of the local part, u***@domain
+	EmailMask EmailPolicy = "mask"
+)
+
+// ParseOutputFormat - format by its name
+func ParseOutputFormat(name string) (OutputFormat, error) {
+	switch format := OutputFormat(name); format {
+	case FormatText, FormatCSV, FormatJSONLines, FormatSummary:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown output format %s", name)
+	}
+}
+
+// ParseEmailPolicy - email policy by its name
+func ParseEmailPolicy(name string) (EmailPolicy, error) {
+	switch policy := EmailPolicy(name); policy {
+	case EmailNone, EmailAt, EmailHash, EmailMask:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown email policy %s", name)
+	}
+}
+
+// appendEmail - appends email obfuscated according to policy
+func appendEmail(buf, email []byte, policy EmailPolicy) []byte {
+	switch policy {
+	case EmailAt:
+		for {
+			at := bytes.IndexByte(email, '@')
+			if at < 0 {
+				break
+			}
+			buf = append(buf, email[:at]...)
+			buf = append(buf, " [at] "...)
+			email = email[at+1:]
+		}
+		return append(buf, email...)
+	case EmailHash:
+		sum := sha256.Sum256(email)
+		n := len(buf)
+		buf = append(buf, make([]byte, hex.EncodedLen(len(sum)))...)
+		hex.Encode(buf[n:], sum[:])
+		return buf
+	case EmailMask:
+		at := bytes.LastIndexByte(email, '@')
+		if at < 0 {
+			at = len(email)
+		}
+		if at > 0 {
+			// whole first rune, its first byte alone is not valid utf-8
+			_, size := utf8.DecodeRune(email[:at])
+			buf = append(buf, email[:size]...)
+		}
+		buf = append(buf, "***"...)
+		return append(buf, email[at:]...)
+	default:
+		return append(buf, email...)
+	}
+}
+
+// ResultWriter - receives search results in file order
+type ResultWriter interface {
+	// Start - called once before the first user
+	Start() error
+	// Found - user at line idx matched, name and email are valid during the call only
+	Found(idx int, name, email []byte) error
+	// Finish - search is over
+	Finish(stats SearchStats) error
+}
+
+// NewResultWriter - writer of format to out
+func NewResultWriter(out io.Writer, format OutputFormat, email EmailPolicy) ResultWriter {
+	switch format {
+	case FormatCSV:
+		return &csvWriter{w: csv.NewWriter(out), email: email}
+	case FormatJSONLines:
+		return &jsonLinesWriter{out: out, enc: json.NewEncoder(out), email: email}
+	case FormatSummary:
+		return &summaryWriter{out: out}
+	default:
+		return &textWriter{out: out, email: email}
+	}
+}
+
+// NewTextWriter - SlowSearch output to out
+func NewTextWriter(out io.Writer) ResultWriter {
+	return NewResultWriter(out, FormatText, EmailAt)
+}
+
+type textWriter struct {
+	out   io.Writer
+	email EmailPolicy
+	buf   []byte
+}
+
+func (tw *textWriter) Start() error {
+	_, err := fmt.Fprintln(tw.out, "found users:")
+	return err
+}
+
+func (tw *textWriter) Found(idx int, name, email []byte) error {
+	// "[i] name <email [at] domain>"
+	buf := append(tw.buf[:0], '[')
+	buf = strconv.AppendInt(buf, int64(idx), 10)
+	buf = append(buf, "] "...)
+	buf = append(buf, name...)
+	buf = append(buf, " <"...)
+	buf = appendEmail(buf, email, tw.email)
+	buf = append(buf, ">\n"...)
+	tw.buf = buf
+	_, err := tw.out.Write(buf)
+	return err
+}
+
+func (tw *textWriter) Finish(stats SearchStats) error {
+	if _, err := fmt.Fprintln(tw.out, "\nTotal unique browsers", stats.UniqueBrowsers); err != nil {
+		return err
+	}
+	if stats.MalformedLines == 0 {
+		return nil
+	}
+	_, err := fmt.Fprintln(tw.out, "Malformed lines", stats.MalformedLines)
+	return err
+}
+
+type csvWriter struct {
+	w     *csv.Writer
+	email EmailPolicy
+	buf   []byte
+}
+
+func (cw *csvWriter) Start() error {
+	return cw.w.Write([]string{"index", "name", "email"})
+}
+
+func (cw *csvWriter) Found(idx int, name, email []byte) error {
+	cw.buf = appendEmail(cw.buf[:0], email, cw.email)
+	return cw.w.Write([]string{strconv.Itoa(idx), string(name), string(cw.buf)})
+}
+
+func (cw *csvWriter) Flush() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+func (cw *csvWriter) Finish(stats SearchStats) error {
+	return cw.Flush()
+}
+
+type jsonUser struct {
+	Index int    `json:"index"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+type jsonLinesWriter struct {
+	out   io.Writer
+	enc   *json.Encoder
+	email EmailPolicy
+	buf   []byte
+}
+
+func (jw *jsonLinesWriter) Start() error {
+	return nil
+}
+
+func (jw *jsonLinesWriter) Found(idx int, name, email []byte) error {
+	jw.buf = appendEmail(jw.buf[:0], email, jw.email)
+	return jw.enc.Encode(jsonUser{Index: idx, Name: string(name), Email: string(jw.buf)})
+}
+
+func (jw *jsonLinesWriter) Finish(stats SearchStats) error {
+	return nil
+}
+
+// SearchSummary - FormatSummary document
+type SearchSummary struct {
+	Users          int `json:"users"`
+	UniqueBrowsers int `json:"unique_browsers"`
+	MalformedLines int `json:"malformed_lines"`
+}
+
+type summaryWriter struct {
+	out     io.Writer
+	summary SearchSummary
+}
+
+func (sw *summaryWriter) Start() error {
+	return nil
+}
+
+func (sw *summaryWriter) Found(idx int, name, email []byte) error {
+	sw.summary.Users++
+	return nil
+}
+
+func (sw *summaryWriter) Finish(stats SearchStats) error {
+	sw.summary.UniqueBrowsers = stats.UniqueBrowsers
+	sw.summary.MalformedLines = stats.MalformedLines
+	return json.NewEncoder(sw.out).Encode(sw.summary)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const outputUsers = `{"browsers":["Android 4","MSIE 8"],"email":"ann@a.com","name":"Ann, \"Jr\""}
+{"browsers":["Opera"],"email":"bob@b.com","name":"Bob"}
+{"browsers":["Android 5","MSIE 9"],"email":"noat","name":"Carl"}`
+
+func TestAppendEmail(t *testing.T) {
+	cases := []struct {
+		email    string
+		policy   EmailPolicy
+		expected string
+	}{
+		{"ann@a.com", EmailNone, "ann@a.com"},
+		{"ann@a.com", EmailAt, "ann [at] a.com"},
+		{"a@b@c", EmailAt, "a [at] b [at] c"},
+		{"ann@a.com", EmailMask, "a***@a.com"},
+		{"@a.com", EmailMask, "***@a.com"},
+		{"noat", EmailMask, "n***"},
+		{"дима@a.com", EmailMask, "д***@a.com"},
+		{"", EmailHash, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+	}
+	for _, c := range cases {
+		if got := string(appendEmail([]byte("x"), []byte(c.email), c.policy)); got != "x"+c.expected {
+			t.Errorf("%s %s: expected %q, got %q", c.policy, c.email, c.expected, got[1:])
+		}
+	}
+}
+
+func searchOutput(t *testing.T, format OutputFormat, email EmailPolicy) string {
+	out := new(bytes.Buffer)
+	if err := FastSearchQuery(NewResultWriter(out, format, email), strings.NewReader(outputUsers), defaultQuery, nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return out.String()
+}
+
+func TestResultWriters(t *testing.T) {
+	cases := []struct {
+		format   OutputFormat
+		email    EmailPolicy
+		expected string
+	}{
+		{FormatText, EmailAt, "found users:\n[0] Ann, \"Jr\" <ann [at] a.com>\n[2] Carl <noat>\n\nTotal unique browsers 4\n"},
+		{FormatCSV, EmailMask, "index,name,email\n0,\"Ann, \"\"Jr\"\"\",a***@a.com\n2,Carl,n***\n"},
+		{FormatJSONLines, EmailNone, `{"index":0,"name":"Ann, \"Jr\"","email":"ann@a.com"}` + "\n" + `{"index":2,"name":"Carl","email":"noat"}` + "\n"},
+		{FormatSummary, EmailHash, `{"users":2,"unique_browsers":4,"malformed_lines":0}` + "\n"},
+	}
+	for _, c := range cases {
+		if got := searchOutput(t, c.format, c.email); got != c.expected {
+			t.Errorf("%s: expected\n%s\ngot\n%s", c.format, c.expected, got)
+		}
+	}
+
+	hashed := searchOutput(t, FormatJSONLines, EmailHash)
+	if strings.Contains(hashed, "ann") || strings.Count(hashed, "\n") != 2 {
+		t.Errorf("expected hashed emails, got %s", hashed)
+	}
+}
+
+func TestParseOutputOptions(t *testing.T) {
+	if _, err := ParseOutputFormat("xml"); err == nil {
+		t.Errorf("expected error for unknown format")
+	}
+	if _, err := ParseEmailPolicy("rot13"); err == nil {
+		t.Errorf("expected error for unknown email policy")
+	}
+	if format, err := ParseOutputFormat("jsonl"); err != nil || format != FormatJSONLines {
+		t.Errorf("unexpected format %s, %v", format, err)
+	}
+
+	out := new(bytes.Buffer)
+	if err := run([]string{"search", "-format", "summary", "-email", "hash"}, out); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.HasPrefix(out.String(), `{"users":`) {
+		t.Errorf("unexpected output %s", out)
+	}
+	if err := run([]string{"search", "-format", "xml"}, out); err == nil {
+		t.Errorf("expected error for unknown format")
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// minChunkSize - smaller chunks cost more in scheduling than they save
+const minChunkSize = 64 << 10
+
+// fileChunk - byte range of the file holding whole lines
+type fileChunk struct {
+	start, end int64
+}
+
+// chunkResult - users found in a chunk, line numbers are local to the chunk
+type chunkResult struct {
+	lines    int
+	found    []foundUser
+	browsers *browserSet
+	// malformed - lines rejected by policy, quarantine - their copies
+	malformed  int
+	quarantine *bytes.Buffer
+	// lineErr - malformed line of strict policy, the chunk stops at it
+	lineErr *LineError
+}
+
+type foundUser struct {
+	line  int
+	name  []byte
+	email []byte
+}
+
+// splitChunks - splits r of size bytes into about n ranges, each range
+// starts right after a newline, so no line is split between two ranges
+func splitChunks(r io.ReaderAt, size int64, n int) ([]fileChunk, error) {
+	if n < 1 {
+		n = 1
+	}
+	step := size / int64(n)
+	if step < minChunkSize {
+		step = minChunkSize
+	}
+
+	chunks := []fileChunk{}
+	buf := make([]byte, 4096)
+	start := int64(0)
+	for start < size {
+		end := start + step
+		if end >= size {
+			chunks = append(chunks, fileChunk{start, size})
+			break
+		}
+		// move end right after the next newline
+		for {
+			read, err := r.ReadAt(buf, end)
+			if idx := bytes.IndexByte(buf[:read], '\n'); idx >= 0 {
+				end += int64(idx) + 1
+				break
+			}
+			end += int64(read)
+			if err == io.EOF || end >= size {
+				end = size
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		chunks = append(chunks, fileChunk{start, end})
+		start = end
+	}
+	return chunks, nil
+}
+
+// searchChunk - FastSearchQuery loop over one chunk, line numbers
+// of results and LineError are local to the chunk
+func searchChunk(r io.ReaderAt, chunk fileChunk, q *Query, policy *LinePolicy) (*chunkResult, error) {
+	res := &chunkResult{browsers: newBrowserSet()}
+	reader := bufio.NewReaderSize(io.NewSectionReader(r, chunk.start, chunk.end-chunk.start), 64<<10)
+	scanner := newUserScanner(q, res.browsers)
+
+	// quarantined lines are written at merge, in file order
+	if policy != nil && policy.Quarantine != nil {
+		res.quarantine = new(bytes.Buffer)
+		policy = &LinePolicy{Strict: policy.Strict, Quarantine: res.quarantine}
+	}
+	checker := newLineChecker(policy)
+	defer func() {
+		res.malformed = checker.malformed
+	}()
+
+	var line, longLine []byte
+	var err error
+	offset := chunk.start
+	for err != io.EOF {
+		line, longLine, err = readLine(reader, longLine)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if err == io.EOF && len(line) == 0 {
+			// chunk ends with newline, next line belongs to next chunk
+			break
+		}
+
+		res.lines++
+		lineOffset := offset
+		offset += int64(len(line))
+		if !checker.checkAll() && q.skipRaw(line) {
+			continue
+		}
+
+		name, email, ok, scanErr := scanner.scan(line)
+		if scanErr != nil {
+			if err := checker.reject(res.lines-1, lineOffset, line, scanErr); err != nil {
+				lineErr, ok := err.(*LineError)
+				if !ok {
+					return nil, err
+				}
+				res.lineErr = lineErr
+				return res, nil
+			}
+			continue
+		}
+		if ok {
+			// name and email point into reader buffer
+			res.found = append(res.found, foundUser{res.lines - 1, append([]byte(nil), name...), append([]byte(nil), email...)})
+		}
+	}
+	return res, nil
+}
+
+// ParallelSearch - FastSearchFile which parses the file in chunks by workers
+// goroutines, all CPUs if workers < 1. Output is the same as of SlowSearch.
+// Compressed dumps can not be split, they are searched sequentially.
+func ParallelSearch(w ResultWriter, path string, q *Query, workers int, policy *LinePolicy) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	head := make([]byte, len(zstdMagic))
+	n, err := file.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if DetectCompression(head[:n]) != CompressionNone {
+		r, err := NewInputReader(file)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		return FastSearchQuery(w, r, q, policy)
+	}
+
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	// a few chunks per worker, so a slow chunk does not hold everyone else
+	chunks, err := splitChunks(file, stat.Size(), 4*workers)
+	if err != nil {
+		return err
+	}
+
+	results := make([]*chunkResult, len(chunks))
+	errs := make([]error, len(chunks))
+	next := make(chan int, len(chunks))
+	for i := range chunks {
+		next <- i
+	}
+	close(next)
+
+	wg := &sync.WaitGroup{}
+	for w := 0; w < workers && w < len(chunks); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i], errs[i] = searchChunk(file, chunks[i], q, policy)
+			}
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	// merge in file order, turning chunk line numbers into file ones
+	seenBrowsers := newBrowserSet()
+	malformed := 0
+	if err := w.Start(); err != nil {
+		return err
+	}
+	lineOffset := 0
+	for _, res := range results {
+		for _, user := range res.found {
+			if err := w.Found(lineOffset+user.line, user.name, user.email); err != nil {
+				return err
+			}
+		}
+		if res.quarantine != nil {
+			if _, err := res.quarantine.WriteTo(policy.Quarantine); err != nil {
+				return err
+			}
+		}
+		if res.lineErr != nil {
+			res.lineErr.Line += lineOffset
+			return res.lineErr
+		}
+		for browser := range res.browsers.seen {
+			seenBrowsers.add(browser)
+		}
+		malformed += res.malformed
+		lineOffset += res.lines
+	}
+	return w.Finish(SearchStats{UniqueBrowsers: seenBrowsers.Len(), MalformedLines: malformed})
+}
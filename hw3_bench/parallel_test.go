@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestSplitChunks(t *testing.T) {
+	lines := []string{}
+	for i := 0; i < 5000; i++ {
+		lines = append(lines, strings.Repeat("x", i%97))
+	}
+	for _, data := range []string{
+		strings.Join(lines, "\n"),
+		strings.Join(lines, "\n") + "\n",
+		strings.Repeat("y", 3*minChunkSize),
+		"",
+	} {
+		for _, n := range []int{1, 2, 3, 10} {
+			chunks, err := splitChunks(strings.NewReader(data), int64(len(data)), n)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			start := int64(0)
+			for _, chunk := range chunks {
+				if chunk.start != start || chunk.end <= chunk.start {
+					t.Fatalf("chunks are not adjacent: %v", chunks)
+				}
+				if chunk.end != int64(len(data)) && data[chunk.end-1] != '\n' {
+					t.Errorf("chunk %v ends inside a line", chunk)
+				}
+				start = chunk.end
+			}
+			if start != int64(len(data)) {
+				t.Errorf("chunks %v do not cover %d bytes", chunks, len(data))
+			}
+		}
+	}
+}
+
+func TestParallelSearch(t *testing.T) {
+	slowOut := new(bytes.Buffer)
+	SlowSearch(slowOut)
+
+	// file is small, chunks are at least minChunkSize
+	for _, workers := range []int{0, 1, 2, 3, 16} {
+		out := new(bytes.Buffer)
+		if err := ParallelSearch(NewTextWriter(out), filePath, defaultQuery, workers, nil); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if out.String() != slowOut.String() {
+			t.Errorf("workers %d: results not match\nGot:\n%v\nExpected:\n%v", workers, out, slowOut)
+		}
+	}
+}
+
+func BenchmarkParallel(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		ParallelSearch(NewTextWriter(ioutil.Discard), filePath, defaultQuery, 0, nil)
+	}
+}
@@ -0,0 +1,524 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// DefaultQuery - what FastSearch looks for
+const DefaultQuery = `browsers ~ "Android" AND browsers ~ "MSIE"`
+
+// maxQueryMatchers - browser matches of a line are kept as bits of uint64
+const maxQueryMatchers = 64
+
+// Query - compiled user predicate, e.g.
+//
+//	browsers ~ "Android" AND (NOT email =~ "\\.edu$" OR name ~ "Sharon")
+//
+// `~` is a substring match, `=~` is a regexp match, browsers matches if any
+// of user browsers does. Browsers may be matched by parsed user agent too:
+//
+//	IE < 9 on Android OR any on iOS device tablet OR "Opera Mini" >= 7
+//
+// family or any, optional version comparison, OS and DeviceClass.
+// Query is not changed once compiled but for its UACache, which is safe for
+// concurrent use, so it may be shared.
+type Query struct {
+	src      string
+	root     queryNode
+	matchers []*fieldMatcher
+	browsers []*fieldMatcher
+	// fields - email and name matchers
+	fields []*fieldMatcher
+	// rawNeedles - substrings a line must contain to have any browser matched,
+	// nil if query has regexps or needles which JSON could escape
+	rawNeedles [][]byte
+	// emptyMatch - result for a user without matched browsers, valid with rawNeedles only
+	emptyMatch bool
+	// ua - parsed browsers of user agent matchers, nil without them
+	ua *UACache
+}
+
+type fieldMatcher struct {
+	idx    int
+	field  string
+	substr string
+	needle []byte
+	re     *regexp.Regexp
+	ua     *uaMatcher
+	cache  *UACache
+}
+
+func (m *fieldMatcher) match(s string) bool {
+	if m.ua != nil {
+		return m.ua.match(m.cache.Parse(s))
+	}
+	if m.re != nil {
+		return m.re.MatchString(s)
+	}
+	return strings.Contains(s, m.substr)
+}
+
+func (m *fieldMatcher) matchBytes(b []byte) bool {
+	if m.ua != nil {
+		return m.ua.match(m.cache.ParseBytes(b))
+	}
+	if m.re != nil {
+		return m.re.Match(b)
+	}
+	return bytes.Contains(b, m.needle)
+}
+
+func (m *fieldMatcher) bit() uint64 {
+	return 1 << uint(m.idx)
+}
+
+// queryNode - evaluates over bits of matched matchers
+type queryNode interface {
+	eval(matched uint64) bool
+}
+
+type andNode struct{ left, right queryNode }
+type orNode struct{ left, right queryNode }
+type notNode struct{ node queryNode }
+type matchNode struct{ m *fieldMatcher }
+
+func (n andNode) eval(matched uint64) bool {
+	return n.left.eval(matched) && n.right.eval(matched)
+}
+
+func (n orNode) eval(matched uint64) bool {
+	return n.left.eval(matched) || n.right.eval(matched)
+}
+
+func (n notNode) eval(matched uint64) bool {
+	return !n.node.eval(matched)
+}
+
+func (n matchNode) eval(matched uint64) bool {
+	return matched&n.m.bit() != 0
+}
+
+// ParseQuery - compiles query expression
+func ParseQuery(src string) (*Query, error) {
+	p := &queryParser{src: src, q: &Query{src: src}}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok != "" {
+		return nil, p.errorf("unexpected %q", p.tok)
+	}
+	p.q.root = root
+	p.q.compileFastPath()
+	return p.q, nil
+}
+
+// MustParseQuery - ParseQuery which panics on error, for queries known at compile time
+func MustParseQuery(src string) *Query {
+	q, err := ParseQuery(src)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+func (q *Query) String() string {
+	return q.src
+}
+
+// Match - evaluates query over user, adds to seen each browser which matched
+// any of browser matchers, whether the user matched or not
+func (q *Query) Match(u *User, seen *browserSet) bool {
+	var matched uint64
+	for _, browser := range u.Browsers {
+		found := false
+		for _, m := range q.browsers {
+			if m.match(browser) {
+				matched |= m.bit()
+				found = true
+			}
+		}
+		if found && seen != nil {
+			seen.add(browser)
+		}
+	}
+	for _, m := range q.fields {
+		value := u.Name
+		if m.field == "email" {
+			value = u.Email
+		}
+		if m.match(value) {
+			matched |= m.bit()
+		}
+	}
+	return q.root.eval(matched)
+}
+
+// matchBrowser - bits of browser matchers which match browser
+func (q *Query) matchBrowser(browser []byte) uint64 {
+	if q == nil {
+		return 0
+	}
+	var matched uint64
+	for _, m := range q.browsers {
+		if m.matchBytes(browser) {
+			matched |= m.bit()
+		}
+	}
+	return matched
+}
+
+// matchRaw - Match for fields of a scanned line, browsers are matched already.
+// nil query matches everyone.
+func (q *Query) matchRaw(browsers uint64, name, email []byte) bool {
+	if q == nil {
+		return true
+	}
+	matched := browsers
+	for _, m := range q.fields {
+		value := name
+		if m.field == "email" {
+			value = email
+		}
+		if m.matchBytes(value) {
+			matched |= m.bit()
+		}
+	}
+	return q.root.eval(matched)
+}
+
+// unicodeEscape - JSON may write any character as \uXXXX, ASCII letters too
+var unicodeEscape = []byte(`\u`)
+
+// skipRaw - line can be skipped without decoding: none of browser matchers
+// can match it and the query does not match users without browsers
+func (q *Query) skipRaw(line []byte) bool {
+	if q.rawNeedles == nil || q.emptyMatch {
+		return false
+	}
+	if bytes.Contains(line, unicodeEscape) {
+		// needles may be escaped, only decoded line tells
+		return false
+	}
+	for _, needle := range q.rawNeedles {
+		if bytes.Contains(line, needle) {
+			return false
+		}
+	}
+	return true
+}
+
+func (q *Query) compileFastPath() {
+	for _, m := range q.matchers {
+		if m.re != nil || m.ua != nil {
+			return
+		}
+		if m.field != "browsers" {
+			// email and name matchers need decoded user anyway
+			return
+		}
+	}
+	needles := make([][]byte, 0, len(q.browsers))
+	for _, m := range q.browsers {
+		for _, r := range m.substr {
+			// JSON encoder may escape these, raw line would not contain the needle as is
+			if r == '"' || r == '\\' || r == '/' || r > unicode.MaxASCII || unicode.IsControl(r) {
+				return
+			}
+		}
+		needles = append(needles, m.needle)
+	}
+	q.rawNeedles = needles
+	q.emptyMatch = q.root.eval(0)
+}
+
+// browserSet - unique browsers seen during the search
+type browserSet struct {
+	seen map[string]struct{}
+}
+
+func newBrowserSet() *browserSet {
+	return &browserSet{seen: make(map[string]struct{}, 128)}
+}
+
+func (bs *browserSet) add(browser string) {
+	if _, ok := bs.seen[browser]; !ok {
+		bs.seen[browser] = struct{}{}
+	}
+}
+
+// addBytes - add without allocation for browsers seen already
+func (bs *browserSet) addBytes(browser []byte) {
+	if _, ok := bs.seen[string(browser)]; !ok {
+		bs.seen[string(browser)] = struct{}{}
+	}
+}
+
+func (bs *browserSet) Len() int {
+	return len(bs.seen)
+}
+
+type queryParser struct {
+	src string
+	pos int
+	// tok - current token: keyword, field, operator, paren or quoted string, "" at the end
+	tok    string
+	tokPos int
+	q      *Query
+}
+
+func (p *queryParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("query %q at %d: %s", p.src, p.tokPos, fmt.Sprintf(format, args...))
+}
+
+func (p *queryParser) next() error {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+	p.tokPos = p.pos
+	if p.pos == len(p.src) {
+		p.tok = ""
+		return nil
+	}
+
+	rest := p.src[p.pos:]
+	switch {
+	case rest[0] == '(' || rest[0] == ')' || rest[0] == '~':
+		p.tok = rest[:1]
+	case strings.HasPrefix(rest, "=~"):
+		p.tok = "=~"
+	case strings.HasPrefix(rest, "<=") || strings.HasPrefix(rest, ">=") || strings.HasPrefix(rest, "!="):
+		p.tok = rest[:2]
+	case rest[0] == '<' || rest[0] == '>' || rest[0] == '=':
+		p.tok = rest[:1]
+	case rest[0] == '"' || rest[0] == '`':
+		quoted, err := strconv.QuotedPrefix(rest)
+		if err != nil {
+			return p.errorf("bad string literal")
+		}
+		p.tok = quoted
+	default:
+		// dots are for versions, e.g. 9.5
+		end := strings.IndexFunc(rest, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '.'
+		})
+		if end == 0 {
+			return p.errorf("unexpected %q", rest[:1])
+		}
+		if end < 0 {
+			end = len(rest)
+		}
+		p.tok = rest[:end]
+	}
+	p.pos += len(p.tok)
+	return nil
+}
+
+func (p *queryParser) keyword(kw string) bool {
+	return strings.EqualFold(p.tok, kw)
+}
+
+func (p *queryParser) parseOr() (queryNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseAnd() (queryNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseNot() (queryNode, error) {
+	if !p.keyword("NOT") {
+		return p.parsePrimary()
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	node, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	return notNode{node}, nil
+}
+
+func (p *queryParser) parsePrimary() (queryNode, error) {
+	if p.tok == "(" {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok != ")" {
+			return nil, p.errorf("expected )")
+		}
+		return node, p.next()
+	}
+
+	field := strings.ToLower(p.tok)
+	switch field {
+	case "browsers", "email", "name":
+	case "":
+		return nil, p.errorf("unexpected end of query")
+	default:
+		return p.parseUserAgent()
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	op := p.tok
+	if op != "~" && op != "=~" {
+		return nil, p.errorf("expected ~ or =~ after %s", field)
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	value, err := strconv.Unquote(p.tok)
+	if err != nil {
+		return nil, p.errorf("expected string after %s", op)
+	}
+
+	m := &fieldMatcher{field: field, substr: value, needle: []byte(value)}
+	if op == "=~" {
+		if m.re, err = regexp.Compile(value); err != nil {
+			return nil, p.errorf("%s", err)
+		}
+	}
+	if err := p.add(m); err != nil {
+		return nil, err
+	}
+	return matchNode{m}, p.next()
+}
+
+func (p *queryParser) add(m *fieldMatcher) error {
+	if len(p.q.matchers) == maxQueryMatchers {
+		return p.errorf("more than %d matchers", maxQueryMatchers)
+	}
+	m.idx = len(p.q.matchers)
+	p.q.matchers = append(p.q.matchers, m)
+	if m.field == "browsers" {
+		p.q.browsers = append(p.q.browsers, m)
+	} else {
+		p.q.fields = append(p.q.fields, m)
+	}
+	return nil
+}
+
+// name - identifier or quoted string, e.g. IE or "Opera Mini"
+func (p *queryParser) name(what string) (string, error) {
+	if p.tok == "" || p.tok[0] == '"' || p.tok[0] == '`' {
+		value, err := strconv.Unquote(p.tok)
+		if err != nil {
+			return "", p.errorf("expected %s", what)
+		}
+		return value, p.next()
+	}
+	if !unicode.IsLetter(rune(p.tok[0])) || p.keyword("AND") || p.keyword("OR") || p.keyword("NOT") {
+		return "", p.errorf("expected %s", what)
+	}
+	value := p.tok
+	return value, p.next()
+}
+
+// parseUserAgent - family [cmp version] [ON os] [DEVICE class]
+func (p *queryParser) parseUserAgent() (queryNode, error) {
+	ua := &uaMatcher{}
+	familyPos := p.tokPos
+	family, err := p.name("field or browser family")
+	if err != nil {
+		return nil, err
+	}
+	if p.tok == "~" || p.tok == "=~" {
+		// misspelled field, not a browser family
+		p.tokPos = familyPos
+		return nil, p.errorf("unknown field %q", family)
+	}
+	if !strings.EqualFold(family, "any") {
+		ua.family = family
+	}
+
+	switch p.tok {
+	case "<", "<=", ">", ">=", "=", "!=":
+		ua.cmp = p.tok
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		for _, part := range strings.Split(p.tok, ".") {
+			n, err := strconv.Atoi(part)
+			if err != nil || n < 0 {
+				return nil, p.errorf("expected version after %s", ua.cmp)
+			}
+			ua.version = append(ua.version, n)
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	if p.keyword("ON") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if ua.os, err = p.name("OS after on"); err != nil {
+			return nil, err
+		}
+	}
+	if p.keyword("DEVICE") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		switch device := DeviceClass(strings.ToLower(p.tok)); device {
+		case DeviceDesktop, DeviceMobile, DeviceTablet, DeviceBot, DeviceOther:
+			ua.device = device
+		default:
+			return nil, p.errorf("unknown device %q", p.tok)
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.q.ua == nil {
+		p.q.ua = NewUACache(defaultUACacheSize)
+	}
+	m := &fieldMatcher{field: "browsers", ua: ua, cache: p.q.ua}
+	if err := p.add(m); err != nil {
+		return nil, err
+	}
+	return matchNode{m}, nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, src := range []string{
+		``,
+		`browsers`,
+		`browsers ~`,
+		`browsers ~ Android`,
+		`phone ~ "1"`,
+		`browsers = "Android"`,
+		`(browsers ~ "Android"`,
+		`browsers ~ "Android" AND`,
+		`browsers ~ "Android" name ~ "x"`,
+		`email =~ "("`,
+		`name ~ "unterminated`,
+		`IE <`,
+		`IE < x`,
+		`IE < 9.x`,
+		`IE on`,
+		`IE on AND name ~ "x"`,
+		`any device phone`,
+		`AND`,
+	} {
+		if _, err := ParseQuery(src); err == nil {
+			t.Errorf("expected error for %q", src)
+		}
+	}
+
+	// misspelled field is reported as such, not as a browser family
+	for _, src := range []string{`nmae ~ "Ann"`, `browsers ~ "x" OR nmae =~ "Ann"`} {
+		if _, err := ParseQuery(src); err == nil || !strings.Contains(err.Error(), `unknown field "nmae"`) {
+			t.Errorf("%s: expected unknown field error, got %v", src, err)
+		}
+	}
+}
+
+func TestQueryMatch(t *testing.T) {
+	u := &User{
+		Browsers: []string{"Mozilla/5.0 (Android 4.0)", "Mozilla/4.0 (compatible; MSIE 8.0)", "Opera/9.80"},
+		Email:    "sharon@muxo.edu",
+		Name:     "Sharon Crawford",
+	}
+	cases := []struct {
+		src   string
+		match bool
+		seen  int
+	}{
+		{DefaultQuery, true, 2},
+		{`browsers ~ "Android" AND NOT browsers ~ "Opera"`, false, 2},
+		{`browsers ~ "Chrome" OR name ~ "Sharon"`, true, 0},
+		{`browsers =~ "MSIE [0-7]\\."`, false, 0},
+		{`browsers =~ "MSIE [0-8]\\."`, true, 1},
+		{`email =~ "\\.edu$" and not (name ~ "John" or name ~ "Jack")`, true, 0},
+		{"NOT NOT email ~ `@muxo`", true, 0},
+		{`IE < 9`, true, 1},
+		{`ie = 8.0 OR "Opera Mini"`, true, 1},
+		{`IE < 8 OR any on Windows`, false, 0},
+		{`any on Android device tablet AND Opera >= 9.8`, true, 2},
+	}
+	for _, c := range cases {
+		q, err := ParseQuery(c.src)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", c.src, err)
+			continue
+		}
+		seen := newBrowserSet()
+		if match := q.Match(u, seen); match != c.match || seen.Len() != c.seen {
+			t.Errorf("%s: expected %v with %d browsers, got %v with %d", c.src, c.match, c.seen, match, seen.Len())
+		}
+	}
+}
+
+func TestQuerySkipRaw(t *testing.T) {
+	line := []byte(`{"browsers":["Opera\/9.80"],"email":"a@b.c","name":"A"}`)
+	cases := []struct {
+		src  string
+		skip bool
+	}{
+		{DefaultQuery, true},
+		{`browsers ~ "Opera"`, false},
+		{`NOT browsers ~ "Android"`, false},
+		{`browsers ~ "Opera/9"`, false},
+		{`browsers =~ "Android"`, false},
+		{`browsers ~ "Android" OR name ~ "A"`, false},
+	}
+	for _, c := range cases {
+		if skip := MustParseQuery(c.src).skipRaw(line); skip != c.skip {
+			t.Errorf("%s: expected skip %v, got %v", c.src, c.skip, skip)
+		}
+	}
+
+	escaped := []byte(`{"browsers":["\u0041ndroid \u004dSIE"],"email":"a@b.c","name":"A"}`)
+	if defaultQuery.skipRaw(escaped) {
+		t.Errorf("line with escaped needles must not be skipped")
+	}
+}
+
+func TestEscapedNeedles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hw3")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// ASCII letters escaped by an encoder, in separate browsers and in one
+	data = append(data, "\n"+
+		`{"browsers":["\u0041ndroid 4.4","\u004dSIE 9.0"],"email":"esc@a.com","name":"Escaped"}`+"\n"+
+		`{"browsers":["M\u0053IE 8.0; \u0041ndroid"],"email":"one@a.com","name":"One"}`+"\n"...)
+	path := filepath.Join(dir, "users.txt")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := new(bytes.Buffer)
+	if err := SlowSearchReader(expected, bytes.NewReader(data), nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.Contains(expected.String(), "Escaped <esc [at] a.com>") || !strings.Contains(expected.String(), "One <one [at] a.com>") {
+		t.Fatalf("unexpected slow output:\n%s", expected)
+	}
+	for name, search := range searchFuncs(dir) {
+		out := new(bytes.Buffer)
+		if err := search(NewTextWriter(out), path, defaultQuery, nil); err != nil {
+			t.Fatalf("%s: unexpected error: %s", name, err)
+		}
+		if out.String() != expected.String() {
+			t.Errorf("%s: results not match\nGot:\n%v\nExpected:\n%v", name, out, expected)
+		}
+	}
+}
+
+func TestFastSearchQuery(t *testing.T) {
+	fastOut := new(bytes.Buffer)
+	FastSearch(fastOut)
+
+	// regexps take the slow path of the same query
+	regexpOut := new(bytes.Buffer)
+	if err := FastSearchFile(NewTextWriter(regexpOut), filePath, MustParseQuery(`browsers =~ "Android" AND browsers =~ "MSIE"`), nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if fastOut.String() != regexpOut.String() {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", regexpOut, fastOut)
+	}
+
+	out := new(bytes.Buffer)
+	if err := FastSearchFile(NewTextWriter(out), filePath, MustParseQuery(`browsers ~ "Android" AND NOT browsers ~ "MSIE" AND email ~ ".edu"`), nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) < 4 {
+		t.Errorf("unexpected result:\n%s", out)
+	}
+	for _, line := range lines[1 : len(lines)-2] {
+		if !strings.Contains(line, ".edu>") {
+			t.Errorf("unexpected user %s", line)
+		}
+	}
+}
+
+func BenchmarkFastRegexp(b *testing.B) {
+	q := MustParseQuery(`browsers =~ "Android" AND browsers =~ "MSIE"`)
+	for i := 0; i < b.N; i++ {
+		FastSearchFile(NewTextWriter(ioutil.Discard), filePath, q, nil)
+	}
+}
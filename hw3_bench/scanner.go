@@ -0,0 +1,491 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+var errUnexpectedEnd = errors.New("unexpected end of line")
+
+// userScanner - walks a raw users line without decoding it into User.
+// Browsers are matched in place, only name and email are kept. Strings
+// with escapes are unescaped into scanner buffers, so after warm up
+// scanning does not allocate. Scanner is not safe for concurrent use.
+type userScanner struct {
+	q    *Query
+	seen *browserSet
+
+	line []byte
+	pos  int
+
+	keyBuf     []byte
+	browserBuf []byte
+	nameBuf    []byte
+	emailBuf   []byte
+	// matched browsers of the line, go to seen only if the whole line is valid
+	matchedBuf  []byte
+	matchedEnds []int
+	// collectAll - keep all browsers of the line for lineBrowser
+	collectAll bool
+	allBuf     []byte
+	allEnds    []int
+}
+
+func newUserScanner(q *Query, seen *browserSet) *userScanner {
+	return &userScanner{q: q, seen: seen}
+}
+
+// scan - matches line against the query, name and email are valid until next scan.
+// Browsers of malformed lines are not added to seen. Lines are judged like
+// encoding/json decoding them into a map does: any valid JSON object is a user,
+// name and email which are not strings are empty, browsers which are not
+// strings are skipped and browsers which are not an array are no browsers.
+func (s *userScanner) scan(line []byte) (name, email []byte, ok bool, err error) {
+	s.line, s.pos = line, 0
+	s.resetBrowsers()
+	var browsers uint64
+
+	s.skipSpaces()
+	if s.peek() == 'n' {
+		// null decodes into an empty map
+		if err := s.literal("null"); err != nil {
+			return nil, nil, false, err
+		}
+		if err := s.end(); err != nil {
+			return nil, nil, false, err
+		}
+		return nil, nil, s.q.matchRaw(0, nil, nil), nil
+	}
+	if err := s.expect('{'); err != nil {
+		return nil, nil, false, err
+	}
+	s.skipSpaces()
+	if s.peek() == '}' {
+		s.pos++
+		if err := s.end(); err != nil {
+			return nil, nil, false, err
+		}
+		return nil, nil, s.q.matchRaw(0, nil, nil), nil
+	}
+
+	for {
+		s.skipSpaces()
+		var key []byte
+		if key, s.keyBuf, err = s.stringValue(s.keyBuf); err != nil {
+			return nil, nil, false, err
+		}
+		s.skipSpaces()
+		if err := s.expect(':'); err != nil {
+			return nil, nil, false, err
+		}
+		s.skipSpaces()
+
+		// the last of repeated keys wins, as in a map
+		switch {
+		case string(key) == "browsers" && s.peek() == '[':
+			if browsers, err = s.browsers(); err != nil {
+				return nil, nil, false, err
+			}
+		case string(key) == "name" && s.peek() == '"':
+			if name, s.nameBuf, err = s.stringValue(s.nameBuf); err != nil {
+				return nil, nil, false, err
+			}
+		case string(key) == "email" && s.peek() == '"':
+			if email, s.emailBuf, err = s.stringValue(s.emailBuf); err != nil {
+				return nil, nil, false, err
+			}
+		default:
+			// values of the user are inside of its object
+			if err := s.skipValue(1); err != nil {
+				return nil, nil, false, err
+			}
+			switch string(key) {
+			case "browsers":
+				browsers = 0
+				s.resetBrowsers()
+			case "name":
+				name = nil
+			case "email":
+				email = nil
+			}
+		}
+
+		s.skipSpaces()
+		switch s.peek() {
+		case ',':
+			s.pos++
+		case '}':
+			s.pos++
+			if err := s.end(); err != nil {
+				return nil, nil, false, err
+			}
+			s.addSeen()
+			return name, email, s.q.matchRaw(browsers, name, email), nil
+		default:
+			return nil, nil, false, s.unexpected()
+		}
+	}
+}
+
+// resetBrowsers - forgets browsers collected from the line so far
+func (s *userScanner) resetBrowsers() {
+	s.matchedBuf, s.matchedEnds = s.matchedBuf[:0], s.matchedEnds[:0]
+	s.allBuf, s.allEnds = s.allBuf[:0], s.allEnds[:0]
+}
+
+func (s *userScanner) browsers() (uint64, error) {
+	s.resetBrowsers()
+	if err := s.expect('['); err != nil {
+		return 0, err
+	}
+	var matched uint64
+	s.skipSpaces()
+	if s.peek() == ']' {
+		s.pos++
+		return 0, nil
+	}
+	for {
+		s.skipSpaces()
+		if s.peek() != '"' {
+			// not a browser, the rest of the array still counts
+			if err := s.skipValue(2); err != nil {
+				return 0, err
+			}
+		} else {
+			var browser []byte
+			var err error
+			if browser, s.browserBuf, err = s.stringValue(s.browserBuf); err != nil {
+				return 0, err
+			}
+			if s.collectAll {
+				s.allBuf = append(s.allBuf, browser...)
+				s.allEnds = append(s.allEnds, len(s.allBuf))
+			}
+			if found := s.q.matchBrowser(browser); found != 0 {
+				matched |= found
+				if s.seen != nil {
+					s.matchedBuf = append(s.matchedBuf, browser...)
+					s.matchedEnds = append(s.matchedEnds, len(s.matchedBuf))
+				}
+			}
+		}
+
+		s.skipSpaces()
+		switch s.peek() {
+		case ',':
+			s.pos++
+		case ']':
+			s.pos++
+			return matched, nil
+		default:
+			return 0, s.unexpected()
+		}
+	}
+}
+
+// lineBrowsers - number of browsers of the last scanned line, needs collectAll
+func (s *userScanner) lineBrowsers() int {
+	return len(s.allEnds)
+}
+
+// lineBrowser - browser i of the last scanned line
+func (s *userScanner) lineBrowser(i int) []byte {
+	start := 0
+	if i > 0 {
+		start = s.allEnds[i-1]
+	}
+	return s.allBuf[start:s.allEnds[i]]
+}
+
+func (s *userScanner) addSeen() {
+	start := 0
+	for _, end := range s.matchedEnds {
+		s.seen.addBytes(s.matchedBuf[start:end])
+		start = end
+	}
+}
+
+func (s *userScanner) peek() byte {
+	if s.pos >= len(s.line) {
+		return 0
+	}
+	return s.line[s.pos]
+}
+
+func (s *userScanner) skipSpaces() {
+	for s.pos < len(s.line) {
+		switch s.line[s.pos] {
+		case ' ', '\t', '\r', '\n':
+			s.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (s *userScanner) expect(c byte) error {
+	if s.peek() != c {
+		return s.unexpected()
+	}
+	s.pos++
+	return nil
+}
+
+// end - only spaces may follow the object
+func (s *userScanner) end() error {
+	s.skipSpaces()
+	if s.pos != len(s.line) {
+		return s.unexpected()
+	}
+	return nil
+}
+
+func (s *userScanner) unexpected() error {
+	if s.pos >= len(s.line) {
+		return errUnexpectedEnd
+	}
+	return fmt.Errorf("unexpected %q at %d", s.line[s.pos], s.pos)
+}
+
+// rawString - string literal as is, without quotes, escapes are checked
+// but not decoded
+func (s *userScanner) rawString() (raw []byte, escaped bool, err error) {
+	if err := s.expect('"'); err != nil {
+		return nil, false, err
+	}
+	start := s.pos
+	for s.pos < len(s.line) {
+		switch c := s.line[s.pos]; {
+		case c == '"':
+			s.pos++
+			return s.line[start : s.pos-1], escaped, nil
+		case c == '\\':
+			escaped = true
+			s.pos++
+			switch s.peek() {
+			case '"', '\\', '/', 'b', 'f', 'n', 'r', 't':
+				s.pos++
+			case 'u':
+				if _, ok := hexRune(s.line[s.pos+1:]); !ok {
+					return nil, false, fmt.Errorf("bad escape at %d", s.pos-1)
+				}
+				s.pos += 5
+			default:
+				return nil, false, s.unexpected()
+			}
+		case c < 0x20:
+			return nil, false, s.unexpected()
+		default:
+			s.pos++
+		}
+	}
+	return nil, false, errUnexpectedEnd
+}
+
+// stringValue - string literal unescaped into buf if it has escapes
+func (s *userScanner) stringValue(buf []byte) (value, newBuf []byte, err error) {
+	raw, escaped, err := s.rawString()
+	if err != nil || !escaped {
+		return raw, buf, err
+	}
+	buf, err = unescapeJSON(buf[:0], raw)
+	return buf, buf, err
+}
+
+// maxNestingDepth - depth of arrays and objects encoding/json gives up at
+const maxNestingDepth = 10000
+
+// skipValue - skips any JSON value checking its grammar, depth - number of
+// arrays and objects the value is in
+func (s *userScanner) skipValue(depth int) error {
+	switch c := s.peek(); {
+	case c == '"':
+		_, _, err := s.rawString()
+		return err
+	case c == '{' || c == '[':
+		if depth >= maxNestingDepth {
+			return fmt.Errorf("exceeded max depth at %d", s.pos)
+		}
+		return s.skipNested(depth + 1)
+	case c == 't':
+		return s.literal("true")
+	case c == 'f':
+		return s.literal("false")
+	case c == 'n':
+		return s.literal("null")
+	case c == '-' || c >= '0' && c <= '9':
+		return s.number()
+	default:
+		return s.unexpected()
+	}
+}
+
+// literal - true, false or null
+func (s *userScanner) literal(word string) error {
+	if len(s.line)-s.pos < len(word) || string(s.line[s.pos:s.pos+len(word)]) != word {
+		return fmt.Errorf("bad literal at %d", s.pos)
+	}
+	s.pos += len(word)
+	return nil
+}
+
+// number - -?(0|[1-9][0-9]*)(.[0-9]+)?([eE][+-]?[0-9]+)?, in float64 range
+// as encoding/json decodes it into float64
+func (s *userScanner) number() error {
+	start := s.pos
+	if s.peek() == '-' {
+		s.pos++
+	}
+	switch c := s.peek(); {
+	case c == '0':
+		s.pos++
+	case c >= '1' && c <= '9':
+		s.digits()
+	default:
+		return s.unexpected()
+	}
+	if s.peek() == '.' {
+		s.pos++
+		if !s.digits() {
+			return s.unexpected()
+		}
+	}
+	if c := s.peek(); c == 'e' || c == 'E' {
+		s.pos++
+		if c := s.peek(); c == '+' || c == '-' {
+			s.pos++
+		}
+		if !s.digits() {
+			return s.unexpected()
+		}
+	}
+	if _, err := strconv.ParseFloat(string(s.line[start:s.pos]), 64); err != nil {
+		return fmt.Errorf("number at %d: %s", start, err)
+	}
+	return nil
+}
+
+// digits - skips [0-9]*, false if there were none
+func (s *userScanner) digits() bool {
+	start := s.pos
+	for c := s.peek(); c >= '0' && c <= '9'; c = s.peek() {
+		s.pos++
+	}
+	return s.pos != start
+}
+
+// skipNested - skips object or array at depth checking its commas and colons
+func (s *userScanner) skipNested(depth int) error {
+	closing := byte(']')
+	object := s.peek() == '{'
+	if object {
+		closing = '}'
+	}
+	s.pos++
+	s.skipSpaces()
+	if s.peek() == closing {
+		s.pos++
+		return nil
+	}
+	for {
+		s.skipSpaces()
+		if object {
+			if _, _, err := s.rawString(); err != nil {
+				return err
+			}
+			s.skipSpaces()
+			if err := s.expect(':'); err != nil {
+				return err
+			}
+			s.skipSpaces()
+		}
+		if err := s.skipValue(depth); err != nil {
+			return err
+		}
+		s.skipSpaces()
+		switch s.peek() {
+		case ',':
+			s.pos++
+		case closing:
+			s.pos++
+			return nil
+		default:
+			return s.unexpected()
+		}
+	}
+}
+
+// unescapeJSON - appends unescaped content of JSON string literal to dst
+func unescapeJSON(dst, src []byte) ([]byte, error) {
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		if c != '\\' {
+			dst = append(dst, c)
+			continue
+		}
+		i++
+		if i == len(src) {
+			return dst, errUnexpectedEnd
+		}
+		switch src[i] {
+		case '"', '\\', '/':
+			dst = append(dst, src[i])
+		case 'b':
+			dst = append(dst, '\b')
+		case 'f':
+			dst = append(dst, '\f')
+		case 'n':
+			dst = append(dst, '\n')
+		case 'r':
+			dst = append(dst, '\r')
+		case 't':
+			dst = append(dst, '\t')
+		case 'u':
+			r, ok := hexRune(src[i+1:])
+			if !ok {
+				return dst, fmt.Errorf("bad escape %q", src[i-1:])
+			}
+			i += 4
+			if utf16.IsSurrogate(r) {
+				// \ud83d\ude00 - surrogate pair, lone surrogate becomes RuneError
+				pair := utf8.RuneError
+				if i+2 < len(src) && src[i+1] == '\\' && src[i+2] == 'u' {
+					if low, ok := hexRune(src[i+3:]); ok {
+						if pair = utf16.DecodeRune(r, low); pair != utf8.RuneError {
+							i += 6
+						}
+					}
+				}
+				r = pair
+			}
+			dst = utf8.AppendRune(dst, r)
+		default:
+			return dst, fmt.Errorf("bad escape %q", src[i-1:i+1])
+		}
+	}
+	return dst, nil
+}
+
+func hexRune(b []byte) (rune, bool) {
+	if len(b) < 4 {
+		return 0, false
+	}
+	var r rune
+	for _, c := range b[:4] {
+		switch {
+		case c >= '0' && c <= '9':
+			c -= '0'
+		case c >= 'a' && c <= 'f':
+			c = c - 'a' + 10
+		case c >= 'A' && c <= 'F':
+			c = c - 'A' + 10
+		default:
+			return 0, false
+		}
+		r = r<<4 | rune(c)
+	}
+	return r, true
+}
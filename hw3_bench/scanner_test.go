@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func readLines(tb testing.TB) [][]byte {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		tb.Fatalf("unexpected error: %s", err)
+	}
+	return bytes.Split(data, []byte("\n"))
+}
+
+func TestUserScannerMatchesEasyJSON(t *testing.T) {
+	for _, src := range []string{DefaultQuery, `browsers =~ "Chrome/4[0-9]" OR email ~ ".edu"`, `NOT name ~ "a"`} {
+		q := MustParseQuery(src)
+		scanSeen, easySeen := newBrowserSet(), newBrowserSet()
+		scanner := newUserScanner(q, scanSeen)
+		for i, line := range readLines(t) {
+			u := &User{}
+			if err := u.UnmarshalJSON(line); err != nil {
+				t.Fatalf("line %d: unexpected error: %s", i, err)
+			}
+			name, email, ok, err := scanner.scan(line)
+			if err != nil {
+				t.Fatalf("line %d: unexpected error: %s", i, err)
+			}
+			if easyOK := q.Match(u, easySeen); ok != easyOK {
+				t.Fatalf("%s, line %d: expected %v, got %v", src, i, easyOK, ok)
+			}
+			if string(name) != u.Name || string(email) != u.Email {
+				t.Fatalf("line %d: expected %s <%s>, got %s <%s>", i, u.Name, u.Email, name, email)
+			}
+		}
+		if scanSeen.Len() != easySeen.Len() {
+			t.Errorf("%s: expected %d browsers, got %d", src, easySeen.Len(), scanSeen.Len())
+		}
+	}
+}
+
+func TestUserScannerLines(t *testing.T) {
+	q := MustParseQuery(`browsers ~ "Android/5" AND name ~ "Ann"`)
+	cases := []struct {
+		line  string
+		name  string
+		email string
+		ok    bool
+	}{
+		{`{"browsers":["Mozilla\/5.0 (Android\/5)"],"name":"Ann","email":"a@b"}`, "Ann", "a@b", true},
+		{` { "name" : "Ann" , "browsers" : [ "Android/5" ] } ` + "\n", "Ann", "", true},
+		{`{"browsers":["Android/5"],"extra":{"name":"Bob","list":[1,"]",{}]},"n":-1.5e3,"t":true,"name":"Ann"}`, "Ann", "", true},
+		{`{"browsers":null,"name":"Ann"}`, "Ann", "", false},
+		{`{"browsers":[],"name":null,"email":"\ud83d\ude00@b"}`, "", "\U0001F600@b", false},
+		{`{}`, "", "", false},
+		{` null `, "", "", false},
+		// types encoding/json decodes into a map without complaint
+		{`{"browsers":["Android/5",5,null,{"a":[]}],"name":"Ann","email":123}`, "Ann", "", true},
+		{`{"browsers":"Android/5","name":"Ann"}`, "Ann", "", false},
+		{`{"browsers":["Android/5"],"name":"Bob","name":["Ann"]}`, "", "", false},
+		{`{"browsers":["Android/5"],"browsers":{},"name":"Ann"}`, "Ann", "", false},
+		{`{"n\u0061me":"Ann","browsers":["Android/5"]}`, "Ann", "", true},
+	}
+	for _, c := range cases {
+		name, email, ok, err := newUserScanner(q, nil).scan([]byte(c.line))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", c.line, err)
+			continue
+		}
+		if string(name) != c.name || string(email) != c.email || ok != c.ok {
+			t.Errorf("%s: expected %q %q %v, got %q %q %v", c.line, c.name, c.email, c.ok, name, email, ok)
+		}
+	}
+}
+
+func TestUserScannerErrors(t *testing.T) {
+	scanner := newUserScanner(defaultQuery, nil)
+	for _, line := range []string{
+		``,
+		`[]`,
+		`{"browsers":["Android"]`,
+		`{"browsers":["Android",]}`,
+		`{"name":"Ann" "email":"a@b"}`,
+		`{"name":"Ann\q"}`,
+		`{"name":"Ann\u00"}`,
+		`{"name":"Ann}`,
+		`{"name":}`,
+		`{"extra":{"a":[}`,
+		`{"name":"Ann"} {}`,
+		"{\"name\":\"A\tnn\"}",
+		`null {}`,
+		// skipped values are checked as strictly as decoded ones
+		`{"x":nul}`,
+		`{"x":tru}`,
+		`{"x":nullx}`,
+		`{"x":abc}`,
+		`{"x":"\q"}`,
+		`{"x":"\u12"}`,
+		`{"x":[1,,2]}`,
+		`{"x":[1,]}`,
+		`{"x":{"a" 1}}`,
+		`{"x":{"a":1,}}`,
+		`{"x":{1:2}}`,
+		`{"x":01}`,
+		`{"x":1.}`,
+		`{"x":-}`,
+		`{"x":1e}`,
+		`{"x":+1}`,
+		`{"x":1e400}`,
+		`{"browsers":["Android",nul]}`,
+		`{"name":tru}`,
+		`{"x":` + strings.Repeat("[", maxNestingDepth) + strings.Repeat("]", maxNestingDepth) + `}`,
+	} {
+		if _, _, _, err := scanner.scan([]byte(line)); err == nil {
+			t.Errorf("%.80s: expected error", line)
+		}
+		// the reference decodes lines into a map
+		if json.Unmarshal([]byte(line), &map[string]interface{}{}) == nil {
+			t.Errorf("%.80s: encoding/json accepts it", line)
+		}
+	}
+}
+
+func TestUnescapeJSON(t *testing.T) {
+	cases := map[string]string{
+		`plain`:              "plain",
+		`a\"b\\c\/d`:         `a"b\c/d`,
+		`\b\f\n\r\t`:         "\b\f\n\r\t",
+		`\u041f\u0440\u0438`: "При",
+		`\ud83d\ude00!`:      "\U0001F600!",
+		`\ud83d!`:            "\uFFFD!",
+		`\ude00\ud83d`:       "\uFFFD\uFFFD",
+		`\ud83d\u0041`:       "\uFFFDA",
+		`\u00e9\u00E9`:       "éé",
+		`tail \u0041BC`:      "tail ABC",
+	}
+	for src, expected := range cases {
+		got, err := unescapeJSON(nil, []byte(src))
+		if err != nil || string(got) != expected {
+			t.Errorf("%s: expected %q, got %q, %v", src, expected, got, err)
+		}
+	}
+}
+
+func TestUserScannerAllocs(t *testing.T) {
+	lines := readLines(t)
+	scanner := newUserScanner(defaultQuery, newBrowserSet())
+	// warm up: browsers go to the set, buffers grow
+	for _, line := range lines {
+		scanner.scan(line)
+	}
+	allocs := testing.AllocsPerRun(10, func() {
+		for _, line := range lines {
+			scanner.scan(line)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("expected no allocations, got %v", allocs)
+	}
+}
+
+// -----
+// go test -bench User -benchmem
+
+func BenchmarkEasyJSONUser(b *testing.B) {
+	lines := readLines(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		seen := newBrowserSet()
+		u := &User{}
+		for _, line := range lines {
+			u.UnmarshalJSON(line)
+			defaultQuery.Match(u, seen)
+		}
+	}
+}
+
+func BenchmarkScanUser(b *testing.B) {
+	lines := readLines(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		scanner := newUserScanner(defaultQuery, newBrowserSet())
+		for _, line := range lines {
+			scanner.scan(line)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+// Dataset - users dump parsed once into memory, read-only, so any number
+// of searches may share it
+type Dataset struct {
+	source    string
+	loadedAt  time.Time
+	lines     int
+	malformed int
+	// browsers - unique browsers of valid lines, users refer to them by position
+	browsers [][]byte
+	users    []datasetUser
+	// userBrowsers - browsers of users[i] are userBrowsers[users[i].start:users[i].end]
+	userBrowsers []uint32
+}
+
+type datasetUser struct {
+	line        int
+	name, email []byte
+	start, end  uint32
+}
+
+// LoadDataset - reads users dump at path, plain or compressed, malformed
+// lines are skipped and counted
+func LoadDataset(path string) (*Dataset, error) {
+	r, err := OpenInput(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	ds := &Dataset{source: path, loadedAt: time.Now()}
+	browserIDs := map[string]uint32{}
+	reader := bufio.NewReaderSize(r, 64<<10)
+	scanner := newUserScanner(nil, nil)
+	scanner.collectAll = true
+	var line, longLine []byte
+	for err != io.EOF {
+		line, longLine, err = readLine(reader, longLine)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if err == io.EOF && len(line) == 0 {
+			// dump ends with newline
+			break
+		}
+		ds.lines++
+		name, email, _, scanErr := scanner.scan(line)
+		if scanErr != nil {
+			ds.malformed++
+			continue
+		}
+
+		u := datasetUser{
+			line:  ds.lines - 1,
+			name:  append([]byte(nil), name...),
+			email: append([]byte(nil), email...),
+			start: uint32(len(ds.userBrowsers)),
+		}
+		for i := 0; i < scanner.lineBrowsers(); i++ {
+			browser := scanner.lineBrowser(i)
+			id, ok := browserIDs[string(browser)]
+			if !ok {
+				id = uint32(len(ds.browsers))
+				browserIDs[string(browser)] = id
+				ds.browsers = append(ds.browsers, append([]byte(nil), browser...))
+			}
+			ds.userBrowsers = append(ds.userBrowsers, id)
+		}
+		u.end = uint32(len(ds.userBrowsers))
+		ds.users = append(ds.users, u)
+	}
+	return ds, nil
+}
+
+// Search - FastSearchQuery over the dataset, users of page [offset, offset+limit)
+// go to w, total is the number of all matched users
+func (ds *Dataset) Search(w ResultWriter, q *Query, offset, limit int) (total int, err error) {
+	// browsers are matched once per query, not once per user having them
+	bits := make([]uint64, len(ds.browsers))
+	uniqueBrowsers := 0
+	for i, browser := range ds.browsers {
+		bits[i] = q.matchBrowser(browser)
+		if bits[i] != 0 {
+			uniqueBrowsers++
+		}
+	}
+
+	if err := w.Start(); err != nil {
+		return 0, err
+	}
+	for _, u := range ds.users {
+		var matched uint64
+		for _, id := range ds.userBrowsers[u.start:u.end] {
+			matched |= bits[id]
+		}
+		if !q.matchRaw(matched, u.name, u.email) {
+			continue
+		}
+		if total >= offset && total-offset < limit {
+			if err := w.Found(u.line, u.name, u.email); err != nil {
+				return 0, err
+			}
+		}
+		total++
+	}
+	return total, w.Finish(SearchStats{UniqueBrowsers: uniqueBrowsers, MalformedLines: ds.malformed})
+}
+
+// DatasetInfo - what /status and /reload report
+type DatasetInfo struct {
+	Source         string    `json:"source"`
+	LoadedAt       time.Time `json:"loaded_at"`
+	Lines          int       `json:"lines"`
+	Users          int       `json:"users"`
+	MalformedLines int       `json:"malformed_lines"`
+	Browsers       int       `json:"browsers"`
+}
+
+func (ds *Dataset) Info() DatasetInfo {
+	return DatasetInfo{
+		Source:         ds.source,
+		LoadedAt:       ds.loadedAt,
+		Lines:          ds.lines,
+		Users:          len(ds.users),
+		MalformedLines: ds.malformed,
+		Browsers:       len(ds.browsers),
+	}
+}
+
+// SearchPage - /search response
+type SearchPage struct {
+	Query          string     `json:"query"`
+	Total          int        `json:"total"`
+	Offset         int        `json:"offset"`
+	Limit          int        `json:"limit"`
+	UniqueBrowsers int        `json:"unique_browsers"`
+	Users          []jsonUser `json:"users"`
+}
+
+// pageWriter - ResultWriter collecting a SearchPage
+type pageWriter struct {
+	page  *SearchPage
+	email EmailPolicy
+	buf   []byte
+}
+
+func (pw *pageWriter) Start() error {
+	pw.page.Users = []jsonUser{}
+	return nil
+}
+
+func (pw *pageWriter) Found(idx int, name, email []byte) error {
+	pw.buf = appendEmail(pw.buf[:0], email, pw.email)
+	pw.page.Users = append(pw.page.Users, jsonUser{Index: idx, Name: string(name), Email: string(pw.buf)})
+	return nil
+}
+
+func (pw *pageWriter) Finish(stats SearchStats) error {
+	pw.page.UniqueBrowsers = stats.UniqueBrowsers
+	return nil
+}
+
+// SearchServer - HTTP search over a Dataset:
+//
+//	GET  /search?query=...&offset=0&limit=100&email=none|at|hash|mask
+//	GET  /status
+//	POST /reload
+//
+// Searches run concurrently over the current dataset, reload parses the
+// dump again and swaps the dataset, searches in flight finish on the old one.
+// Errors are {"error": "..."} with 4xx or 5xx status.
+type SearchServer struct {
+	source string
+	// data - current *Dataset
+	data atomic.Value
+	// reloadMu - one reload at a time
+	reloadMu sync.Mutex
+	mux      *http.ServeMux
+}
+
+// NewSearchServer - loads dump at source, fails if it can not be loaded
+func NewSearchServer(source string) (*SearchServer, error) {
+	srv := &SearchServer{source: source, mux: http.NewServeMux()}
+	if _, err := srv.Reload(); err != nil {
+		return nil, err
+	}
+	srv.mux.HandleFunc("/search", srv.handleSearch)
+	srv.mux.HandleFunc("/status", srv.handleStatus)
+	srv.mux.HandleFunc("/reload", srv.handleReload)
+	return srv, nil
+}
+
+func (srv *SearchServer) dataset() *Dataset {
+	return srv.data.Load().(*Dataset)
+}
+
+// Reload - loads the dump again, the old dataset stays if it fails
+func (srv *SearchServer) Reload() (*Dataset, error) {
+	srv.reloadMu.Lock()
+	defer srv.reloadMu.Unlock()
+	ds, err := LoadDataset(srv.source)
+	if err != nil {
+		return nil, err
+	}
+	srv.data.Store(ds)
+	return ds, nil
+}
+
+func (srv *SearchServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	srv.mux.ServeHTTP(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// intParam - non-negative integer form value, def if missing
+func intParam(r *http.Request, name string, def int) (int, error) {
+	value := r.FormValue(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer", name)
+	}
+	return n, nil
+}
+
+func (srv *SearchServer) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s is not allowed", r.Method))
+		return
+	}
+	src := r.FormValue("query")
+	if src == "" {
+		src = DefaultQuery
+	}
+	q, err := ParseQuery(src)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	offset, err := intParam(r, "offset", 0)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	limit, err := intParam(r, "limit", defaultPageLimit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+	email := EmailAt
+	if name := r.FormValue("email"); name != "" {
+		if email, err = ParseEmailPolicy(name); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	page := &SearchPage{Query: q.String(), Offset: offset, Limit: limit}
+	if page.Total, err = srv.dataset().Search(&pageWriter{page: page, email: email}, q, offset, limit); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (srv *SearchServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s is not allowed", r.Method))
+		return
+	}
+	writeJSON(w, http.StatusOK, srv.dataset().Info())
+}
+
+func (srv *SearchServer) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s is not allowed", r.Method))
+		return
+	}
+	ds, err := srv.Reload()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ds.Info())
+}
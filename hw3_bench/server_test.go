@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var serverQueries = []string{
+	DefaultQuery,
+	`browsers =~ "MSIE [6-8]" AND email ~ ".edu"`,
+	`NOT browsers ~ "Android"`,
+	`name ~ "Ann" OR IE < 9 on Windows`,
+}
+
+func TestDatasetSearch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hw3")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	malformedPath, _ := writeMalformedUsers(t, dir)
+
+	for _, path := range []string{filePath, malformedPath} {
+		ds, err := LoadDataset(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if ds.lines != 1000 || ds.malformed+len(ds.users) != 1000 {
+			t.Errorf("%s: unexpected %+v", path, ds.Info())
+		}
+		for _, src := range serverQueries {
+			q := MustParseQuery(src)
+			for _, format := range []OutputFormat{FormatJSONLines, FormatSummary} {
+				expected, out := new(bytes.Buffer), new(bytes.Buffer)
+				if err := FastSearchFile(NewResultWriter(expected, format, EmailNone), path, q, &LinePolicy{}); err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				total, err := ds.Search(NewResultWriter(out, format, EmailNone), q, 0, 1000)
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				if out.String() != expected.String() {
+					t.Errorf("%s %s %s: results not match\nGot:\n%v\nExpected:\n%v", path, src, format, out, expected)
+				}
+				if format == FormatJSONLines && total != strings.Count(expected.String(), "\n") {
+					t.Errorf("%s %s: unexpected total %d", path, src, total)
+				}
+			}
+		}
+	}
+}
+
+func getJSON(t *testing.T, srv http.Handler, method, target string, status int, v interface{}) {
+	req := httptest.NewRequest(method, target, nil)
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != status {
+		t.Fatalf("%s %s: expected status %d, got %d: %s", method, target, status, rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("%s %s: unexpected content type %s", method, target, ct)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("%s %s: bad JSON %s: %s", method, target, rec.Body, err)
+	}
+}
+
+func TestSearchServerPages(t *testing.T) {
+	srv, err := NewSearchServer(filePath)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	src := `browsers ~ "Android"`
+	all := SearchPage{}
+	getJSON(t, srv, "GET", "/search?limit=1000&email=none&query="+url.QueryEscape(src), http.StatusOK, &all)
+	if all.Total != len(all.Users) || all.Total < 100 || all.Query != src {
+		t.Fatalf("unexpected page %+v", all)
+	}
+
+	for _, c := range []struct{ offset, limit int }{{0, 10}, {7, 13}, {all.Total - 5, 10}, {all.Total + 1, 10}, {3, 0}} {
+		page := SearchPage{}
+		getJSON(t, srv, "GET", fmt.Sprintf("/search?offset=%d&limit=%d&email=none&query=%s", c.offset, c.limit, url.QueryEscape(src)), http.StatusOK, &page)
+		expected := []jsonUser{}
+		if c.offset < all.Total {
+			end := c.offset + c.limit
+			if end > all.Total {
+				end = all.Total
+			}
+			expected = all.Users[c.offset:end]
+		}
+		if page.Total != all.Total || page.UniqueBrowsers != all.UniqueBrowsers || fmt.Sprint(page.Users) != fmt.Sprint(expected) {
+			t.Errorf("offset %d limit %d: unexpected page %+v", c.offset, c.limit, page)
+		}
+	}
+
+	// defaults are the default query, 100 users and emails as SlowSearch prints them
+	page := SearchPage{}
+	getJSON(t, srv, "GET", "/search", http.StatusOK, &page)
+	if page.Query != DefaultQuery || page.Limit != defaultPageLimit || page.UniqueBrowsers != 114 || len(page.Users) != page.Total {
+		t.Errorf("unexpected page %+v", page)
+	}
+	for _, u := range page.Users {
+		if !strings.Contains(u.Email, " [at] ") {
+			t.Errorf("unexpected email %s", u.Email)
+		}
+	}
+	getJSON(t, srv, "GET", "/search?limit=100000", http.StatusOK, &page)
+	if page.Limit != maxPageLimit {
+		t.Errorf("expected limit %d, got %d", maxPageLimit, page.Limit)
+	}
+}
+
+func TestSearchServerErrors(t *testing.T) {
+	srv, err := NewSearchServer(filePath)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, c := range []struct {
+		method, target string
+		status         int
+	}{
+		{"GET", "/search?query=" + url.QueryEscape(`browsers ~`), http.StatusBadRequest},
+		{"GET", "/search?offset=-1", http.StatusBadRequest},
+		{"GET", "/search?limit=ten", http.StatusBadRequest},
+		{"GET", "/search?email=rot13", http.StatusBadRequest},
+		{"POST", "/search", http.StatusMethodNotAllowed},
+		{"GET", "/reload", http.StatusMethodNotAllowed},
+		{"POST", "/status", http.StatusMethodNotAllowed},
+	} {
+		resp := map[string]string{}
+		getJSON(t, srv, c.method, c.target, c.status, &resp)
+		if resp["error"] == "" {
+			t.Errorf("%s %s: expected error message, got %v", c.method, c.target, resp)
+		}
+	}
+
+	if _, err := NewSearchServer("./data/missing.txt"); err == nil {
+		t.Errorf("expected error for missing dump")
+	}
+}
+
+func TestSearchServerReload(t *testing.T) {
+	dir, source := copyUsers(t)
+	defer os.RemoveAll(dir)
+	srv, err := NewSearchServer(source)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	before := SearchPage{}
+	getJSON(t, srv, "GET", "/search", http.StatusOK, &before)
+
+	// searches keep going while the dump is reloaded
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				// no Fatal out of the test goroutine
+				rec := httptest.NewRecorder()
+				srv.ServeHTTP(rec, httptest.NewRequest("GET", "/search", nil))
+				page := SearchPage{}
+				if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil || rec.Code != http.StatusOK {
+					t.Errorf("unexpected response %d %s", rec.Code, rec.Body)
+				}
+				if page.Total != before.Total && page.Total != before.Total+1 {
+					t.Errorf("unexpected total %d", page.Total)
+				}
+			}
+		}()
+	}
+
+	file, err := os.OpenFile(source, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	fmt.Fprint(file, "\n"+`{"browsers":["Android 9","MSIE 5"],"email":"new@user.com","name":"New User"}`+"\nbroken\n")
+	file.Close()
+	info := DatasetInfo{}
+	getJSON(t, srv, "POST", "/reload", http.StatusOK, &info)
+	wg.Wait()
+	if info.Lines != 1002 || info.Users != 1001 || info.MalformedLines != 1 || info.Source != source {
+		t.Errorf("unexpected info %+v", info)
+	}
+
+	after := SearchPage{}
+	getJSON(t, srv, "GET", "/search?limit=1000&email=none", http.StatusOK, &after)
+	last := after.Users[len(after.Users)-1]
+	if after.Total != before.Total+1 || last.Index != 1000 || last.Email != "new@user.com" || after.UniqueBrowsers != before.UniqueBrowsers+2 {
+		t.Errorf("unexpected page after reload %+v", after)
+	}
+
+	// failed reload keeps the old dataset
+	os.Remove(source)
+	resp := map[string]string{}
+	getJSON(t, srv, "POST", "/reload", http.StatusInternalServerError, &resp)
+	getJSON(t, srv, "GET", "/status", http.StatusOK, &info)
+	if info.Users != 1001 {
+		t.Errorf("unexpected info after failed reload %+v", info)
+	}
+}
+
+func BenchmarkDatasetSearch(b *testing.B) {
+	ds, err := LoadDataset(filePath)
+	if err != nil {
+		b.Fatalf("unexpected error: %s", err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ds.Search(NewTextWriter(ioutil.Discard), defaultQuery, 0, maxPageLimit)
+	}
+}
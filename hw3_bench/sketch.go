@@ -0,0 +1,138 @@
+package main
+
+import (
+	"container/heap"
+	"math"
+	"math/bits"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// hllPrecision - 2^14 registers, 16KB, standard error 0.81%
+const hllPrecision = 14
+
+// HyperLogLog - distinct count estimation in constant memory
+type HyperLogLog struct {
+	registers []uint8
+}
+
+func NewHyperLogLog() *HyperLogLog {
+	return &HyperLogLog{registers: make([]uint8, 1<<hllPrecision)}
+}
+
+// Add - counts item, it is hashed, so item may be reused after the call
+func (h *HyperLogLog) Add(item []byte) {
+	hash := xxhash.Sum64(item)
+	idx := hash >> (64 - hllPrecision)
+	// position of the first 1 bit in the rest of the hash, the rest
+	// is shifted left so zeros of the index part do not count
+	rank := uint8(bits.LeadingZeros64(hash<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	if rank > h.registers[idx] {
+		h.registers[idx] = rank
+	}
+}
+
+// Merge - h counts items of other too
+func (h *HyperLogLog) Merge(other *HyperLogLog) {
+	for i, rank := range other.registers {
+		if rank > h.registers[i] {
+			h.registers[i] = rank
+		}
+	}
+}
+
+// Count - estimated number of distinct items
+func (h *HyperLogLog) Count() uint64 {
+	m := float64(len(h.registers))
+	sum := 0.0
+	zeros := 0
+	for _, rank := range h.registers {
+		sum += 1 / float64(uint64(1)<<rank)
+		if rank == 0 {
+			zeros++
+		}
+	}
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+	// small cardinalities are better counted by empty registers
+	if estimate <= 2.5*m && zeros != 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
+
+// TopCounter - Space-Saving top-N in constant memory: keeps capacity
+// counters, new item takes the place of the least frequent one.
+// Counts are overestimated by at most Error of the item.
+type TopCounter struct {
+	capacity int
+	items    map[string]*topItem
+	heap     topHeap
+}
+
+type topItem struct {
+	key   string
+	count uint64
+	err   uint64
+	idx   int
+}
+
+// topHeap - min-heap of counters by count
+type topHeap []*topItem
+
+func (th topHeap) Len() int           { return len(th) }
+func (th topHeap) Less(i, j int) bool { return th[i].count < th[j].count }
+func (th topHeap) Swap(i, j int) {
+	th[i], th[j] = th[j], th[i]
+	th[i].idx, th[j].idx = i, j
+}
+func (th *topHeap) Push(x interface{}) {
+	item := x.(*topItem)
+	item.idx = len(*th)
+	*th = append(*th, item)
+}
+func (th *topHeap) Pop() interface{} {
+	old := *th
+	item := old[len(old)-1]
+	*th = old[:len(old)-1]
+	return item
+}
+
+func NewTopCounter(capacity int) *TopCounter {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &TopCounter{capacity: capacity, items: make(map[string]*topItem, capacity)}
+}
+
+// Add - counts item, it may be reused after the call
+func (tc *TopCounter) Add(item []byte) {
+	if it, ok := tc.items[string(item)]; ok {
+		it.count++
+		heap.Fix(&tc.heap, it.idx)
+		return
+	}
+	if len(tc.heap) < tc.capacity {
+		it := &topItem{key: string(item), count: 1}
+		tc.items[it.key] = it
+		heap.Push(&tc.heap, it)
+		return
+	}
+	// the least frequent item gives its counter away
+	min := tc.heap[0]
+	delete(tc.items, min.key)
+	min.key = string(item)
+	min.err = min.count
+	min.count++
+	tc.items[min.key] = min
+	heap.Fix(&tc.heap, 0)
+}
+
+// Top - n most frequent items, most frequent first
+func (tc *TopCounter) Top(n int) []BrowserCount {
+	counts := make([]BrowserCount, 0, len(tc.heap))
+	for _, it := range tc.heap {
+		counts = append(counts, BrowserCount{Browser: it.key, Count: it.count, Error: it.err})
+	}
+	return topCounts(counts, n)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// minTopCapacity - approximate top is exact for dumps with fewer distinct browsers
+const minTopCapacity = 1024
+
+// StatsOptions - what BrowserStats counts
+type StatsOptions struct {
+	// TopN - number of most frequent browsers to report
+	TopN int
+	// Approximate - HyperLogLog distinct count and Space-Saving top
+	// in constant memory instead of exact counts of every browser
+	Approximate bool
+	// TopCapacity - counters kept by approximate top, 10*TopN but at least
+	// minTopCapacity if 0
+	TopCapacity int
+	// Query - users to count browsers of, nil for everyone
+	Query  *Query
+	Policy *LinePolicy
+}
+
+// BrowserCount - browser and number of users having it
+type BrowserCount struct {
+	Browser string `json:"browser"`
+	Count   uint64 `json:"count"`
+	// Error - max overestimation of Count in approximate mode
+	Error uint64 `json:"error,omitempty"`
+}
+
+// BrowserReport - result of BrowserStats
+type BrowserReport struct {
+	Users          uint64         `json:"users"`
+	Distinct       uint64         `json:"distinct"`
+	Approximate    bool           `json:"approximate"`
+	Top            []BrowserCount `json:"top"`
+	MalformedLines int            `json:"malformed_lines"`
+}
+
+// topCounts - n largest counts, ties ordered by browser
+func topCounts(counts []BrowserCount, n int) []BrowserCount {
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Browser < counts[j].Browser
+	})
+	if n >= 0 && len(counts) > n {
+		counts = counts[:n]
+	}
+	return counts
+}
+
+// browserCounter - exact or approximate counts of browsers
+type browserCounter interface {
+	Add(browser []byte)
+	report(report *BrowserReport, topN int)
+}
+
+type exactCounter map[string]uint64
+
+func (ec exactCounter) Add(browser []byte) {
+	ec[string(browser)]++
+}
+
+func (ec exactCounter) report(report *BrowserReport, topN int) {
+	counts := make([]BrowserCount, 0, len(ec))
+	for browser, count := range ec {
+		counts = append(counts, BrowserCount{Browser: browser, Count: count})
+	}
+	report.Distinct = uint64(len(ec))
+	report.Top = topCounts(counts, topN)
+}
+
+type approxCounter struct {
+	hll *HyperLogLog
+	top *TopCounter
+}
+
+func (ac *approxCounter) Add(browser []byte) {
+	ac.hll.Add(browser)
+	ac.top.Add(browser)
+}
+
+func (ac *approxCounter) report(report *BrowserReport, topN int) {
+	report.Approximate = true
+	report.Distinct = ac.hll.Count()
+	report.Top = ac.top.Top(topN)
+}
+
+// BrowserStats - top browsers and number of distinct browsers of users
+// matching opts.Query, a browser counts once per user
+func BrowserStats(r io.Reader, opts StatsOptions) (*BrowserReport, error) {
+	var counter browserCounter = exactCounter{}
+	if opts.Approximate {
+		capacity := opts.TopCapacity
+		if capacity == 0 {
+			capacity = 10 * opts.TopN
+			if capacity < minTopCapacity {
+				capacity = minTopCapacity
+			}
+		}
+		counter = &approxCounter{hll: NewHyperLogLog(), top: NewTopCounter(capacity)}
+	}
+
+	reader := bufio.NewReaderSize(r, 64<<10)
+	scanner := newUserScanner(opts.Query, nil)
+	scanner.collectAll = true
+	checker := newLineChecker(opts.Policy)
+	report := &BrowserReport{}
+
+	var line, longLine []byte
+	var err error
+	offset := int64(0)
+	for cnt := 0; err != io.EOF; cnt++ {
+		line, longLine, err = readLine(reader, longLine)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if err == io.EOF && len(line) == 0 {
+			break
+		}
+		lineOffset := offset
+		offset += int64(len(line))
+
+		_, _, ok, scanErr := scanner.scan(line)
+		if scanErr != nil {
+			if err := checker.reject(cnt, lineOffset, line, scanErr); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if !ok {
+			continue
+		}
+		report.Users++
+	browsers:
+		for i := 0; i < scanner.lineBrowsers(); i++ {
+			browser := scanner.lineBrowser(i)
+			// same browser twice in a user counts once
+			for j := 0; j < i; j++ {
+				if string(scanner.lineBrowser(j)) == string(browser) {
+					continue browsers
+				}
+			}
+			counter.Add(browser)
+		}
+	}
+
+	counter.report(report, opts.TopN)
+	report.MalformedLines = checker.malformed
+	return report, nil
+}
+
+// BrowserStatsFile - BrowserStats over users dump at path, plain or compressed
+func BrowserStatsFile(path string, opts StatsOptions) (*BrowserReport, error) {
+	r, err := OpenInput(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return BrowserStats(r, opts)
+}
+
+// WriteText - report for people
+func (br *BrowserReport) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	approx := ""
+	if br.Approximate {
+		approx = " (approximate)"
+	}
+	fmt.Fprintf(bw, "users %d\ndistinct browsers %d%s\n", br.Users, br.Distinct, approx)
+	if br.MalformedLines != 0 {
+		fmt.Fprintf(bw, "malformed lines %d\n", br.MalformedLines)
+	}
+	fmt.Fprintf(bw, "top %d browsers:\n", len(br.Top))
+	for _, bc := range br.Top {
+		if bc.Error != 0 {
+			fmt.Fprintf(bw, "%8d ±%d %s\n", bc.Count, bc.Error, bc.Browser)
+		} else {
+			fmt.Fprintf(bw, "%8d %s\n", bc.Count, bc.Browser)
+		}
+	}
+	return bw.Flush()
+}
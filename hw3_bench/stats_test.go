@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strings"
+	"testing"
+)
+
+// referenceCounts - browser counts by easyjson, once per user
+func referenceCounts(t *testing.T, q *Query) (map[string]uint64, uint64) {
+	counts := map[string]uint64{}
+	users := uint64(0)
+	for _, line := range readLines(t) {
+		u := &User{}
+		if err := u.UnmarshalJSON(line); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if q != nil && !q.Match(u, nil) {
+			continue
+		}
+		users++
+		seen := map[string]bool{}
+		for _, browser := range u.Browsers {
+			if !seen[browser] {
+				seen[browser] = true
+				counts[browser]++
+			}
+		}
+	}
+	return counts, users
+}
+
+func TestBrowserStatsExact(t *testing.T) {
+	for _, q := range []*Query{nil, defaultQuery} {
+		counts, users := referenceCounts(t, q)
+		for _, approximate := range []bool{false, true} {
+			// approximate top has more counters than there are browsers, so it is exact too
+			report, err := BrowserStatsFile(filePath, StatsOptions{TopN: 5, Approximate: approximate, Query: q})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if report.Users != users || len(report.Top) != 5 {
+				t.Errorf("expected %d users and top 5, got %d and %d", users, report.Users, len(report.Top))
+			}
+			for i, bc := range report.Top {
+				if bc.Count != counts[bc.Browser] || bc.Error != 0 {
+					t.Errorf("%s: expected %d, got %d±%d", bc.Browser, counts[bc.Browser], bc.Count, bc.Error)
+				}
+				if i > 0 && report.Top[i-1].Count < bc.Count {
+					t.Errorf("top is not sorted: %v", report.Top)
+				}
+			}
+			// no browser outside of the top is more frequent than its last one
+			last := report.Top[len(report.Top)-1]
+			for browser, count := range counts {
+				if count > last.Count {
+					found := false
+					for _, bc := range report.Top {
+						found = found || bc.Browser == browser
+					}
+					if !found {
+						t.Errorf("%s with %d users missed the top", browser, count)
+					}
+				}
+			}
+
+			distinct := float64(len(counts))
+			if !approximate && report.Distinct != uint64(len(counts)) {
+				t.Errorf("expected %d distinct, got %d", len(counts), report.Distinct)
+			}
+			if approximate && math.Abs(float64(report.Distinct)-distinct) > 0.03*distinct {
+				t.Errorf("expected about %d distinct, got %d", len(counts), report.Distinct)
+			}
+		}
+	}
+}
+
+func TestHyperLogLog(t *testing.T) {
+	hll, other := NewHyperLogLog(), NewHyperLogLog()
+	if hll.Count() != 0 {
+		t.Errorf("expected empty count, got %d", hll.Count())
+	}
+	for i := 0; i < 200000; i++ {
+		item := []byte(fmt.Sprintf("browser %d", i%100000))
+		if i < 150000 {
+			hll.Add(item)
+		} else {
+			other.Add(item)
+		}
+	}
+	if count := hll.Count(); math.Abs(float64(count)-100000) > 3000 {
+		t.Errorf("expected about 100000, got %d", count)
+	}
+	other.Merge(hll)
+	if count := other.Count(); math.Abs(float64(count)-100000) > 3000 {
+		t.Errorf("expected about 100000 after merge, got %d", count)
+	}
+}
+
+func TestTopCounter(t *testing.T) {
+	tc := NewTopCounter(50)
+	// heavy hitters among a long tail of unique items, more frequent than
+	// total/capacity, so Space-Saving is sure to keep them
+	for i := 0; i < 10000; i++ {
+		tc.Add([]byte(fmt.Sprintf("tail %d", i)))
+		if i%10 == 0 {
+			tc.Add([]byte("heavy"))
+		}
+		if i%20 == 0 {
+			tc.Add([]byte("medium"))
+		}
+	}
+	top := tc.Top(2)
+	if len(top) != 2 || top[0].Browser != "heavy" || top[1].Browser != "medium" {
+		t.Fatalf("expected heavy and medium, got %v", top)
+	}
+	for _, bc := range top {
+		real := map[string]uint64{"heavy": 1000, "medium": 500}[bc.Browser]
+		if bc.Count < real || bc.Count-bc.Error > real {
+			t.Errorf("%s: expected %d within %d±%d", bc.Browser, real, bc.Count, bc.Error)
+		}
+	}
+	if len(tc.Top(100)) != 50 {
+		t.Errorf("expected 50 counters, got %d", len(tc.Top(100)))
+	}
+}
+
+func TestBrowserStatsMalformed(t *testing.T) {
+	data := `{"browsers":["A","B","A"]}` + "\nbroken\n" + `{"browsers":["B"]}`
+	if _, err := BrowserStats(strings.NewReader(data), StatsOptions{Policy: &LinePolicy{Strict: true}}); err == nil {
+		t.Errorf("expected error in strict mode")
+	}
+	report, err := BrowserStats(strings.NewReader(data), StatsOptions{TopN: 10, Policy: &LinePolicy{}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []BrowserCount{{Browser: "B", Count: 2}, {Browser: "A", Count: 1}}
+	if report.Users != 2 || report.MalformedLines != 1 || fmt.Sprint(report.Top) != fmt.Sprint(expected) {
+		t.Errorf("unexpected report %+v", report)
+	}
+}
+
+func TestRunStats(t *testing.T) {
+	out := new(bytes.Buffer)
+	if err := run([]string{"stats", "-top", "3", "-approx"}, out); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.HasPrefix(out.String(), "users 1000\ndistinct browsers") || !strings.Contains(out.String(), "(approximate)\ntop 3 browsers:\n") {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+	out.Reset()
+	if err := run([]string{"stats", "-format", "json", "-query", DefaultQuery}, out); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.HasPrefix(out.String(), `{"users":`) {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+	for _, args := range [][]string{
+		{"stats", "-format", "xml"},
+		{"stats", "-query", "browsers"},
+	} {
+		if err := run(args, ioutil.Discard); err == nil {
+			t.Errorf("%v: expected error", args)
+		}
+	}
+}
+
+func BenchmarkBrowserStats(b *testing.B) {
+	for _, approximate := range []bool{false, true} {
+		b.Run(fmt.Sprintf("approximate=%v", approximate), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				BrowserStatsFile(filePath, StatsOptions{TopN: 10, Approximate: approximate})
+			}
+		})
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"strings"
+	"sync"
+)
+
+// DeviceClass - kind of device a browser runs on
+type DeviceClass string
+
+const (
+	DeviceDesktop DeviceClass = "desktop"
+	DeviceMobile  DeviceClass = "mobile"
+	DeviceTablet  DeviceClass = "tablet"
+	DeviceBot     DeviceClass = "bot"
+	// DeviceOther - libraries, validators and whatever is not recognized
+	DeviceOther DeviceClass = "other"
+)
+
+// UnknownOS - OS of user agents which do not tell it
+const UnknownOS = "Other"
+
+// UserAgent - parsed browser string, e.g.
+//
+//	Mozilla/4.0 (compatible; MSIE 6.0; Windows CE; IEMobile 7.11)
+//
+// is IE 6.0 on Windows CE, mobile
+type UserAgent struct {
+	Family  string
+	Version string
+	OS      string
+	Device  DeviceClass
+}
+
+// uaFamily - browser recognized by token, the first matching rule wins,
+// so more specific browsers go before the ones they pretend to be
+type uaFamily struct {
+	token  string
+	family string
+	// versionToken - version is taken after it instead of token, if present
+	versionToken string
+	// requires - token is not enough, e.g. Safari needs Version/
+	requires string
+}
+
+var uaFamilies = []uaFamily{
+	{token: "Edge/", family: "Edge"},
+	{token: "Edg/", family: "Edge"},
+	{token: "OPR/", family: "Opera"},
+	{token: "Opera Mini/", family: "Opera Mini"},
+	{token: "Opera/", family: "Opera", versionToken: "Version/"},
+	{token: "Opera ", family: "Opera"},
+	{token: "YaBrowser/", family: "Yandex"},
+	{token: "Vivaldi/", family: "Vivaldi"},
+	{token: "Maxthon/", family: "Maxthon"},
+	{token: "UCBrowser/", family: "UC Browser"},
+	{token: "SamsungBrowser/", family: "Samsung Internet"},
+	{token: "Puffin/", family: "Puffin"},
+	{token: "Silk/", family: "Silk"},
+	{token: "QupZilla/", family: "QupZilla"},
+	{token: "Arora/", family: "Arora"},
+	{token: "NokiaBrowser/", family: "Nokia Browser"},
+	{token: "SeaMonkey/", family: "SeaMonkey"},
+	{token: "Iceape/", family: "SeaMonkey"},
+	{token: "Chromium/", family: "Chromium"},
+	{token: "CriOS/", family: "Chrome"},
+	{token: "Chrome/", family: "Chrome"},
+	{token: "FxiOS/", family: "Firefox"},
+	{token: "Fennec/", family: "Firefox"},
+	{token: "Firefox/", family: "Firefox"},
+	{token: "IEMobile/", family: "IE Mobile"},
+	{token: "IEMobile ", family: "IE Mobile"},
+	{token: "MSIE ", family: "IE"},
+	{token: "Trident/", family: "IE", versionToken: "rv:", requires: "rv:"},
+	{token: "Konqueror/", family: "Konqueror"},
+	{token: "NetFront/", family: "NetFront"},
+	{token: "Android", family: "Android Browser", versionToken: "Version/", requires: "Version/"},
+	{token: "Safari/", family: "Safari", versionToken: "Version/", requires: "Version/"},
+	{token: "Safari/", family: "Safari"},
+}
+
+// uaSystem - OS recognized by any of tokens, the first matching rule wins
+type uaSystem struct {
+	tokens []string
+	os     string
+}
+
+var uaSystems = []uaSystem{
+	{[]string{"Windows Phone"}, "Windows Phone"},
+	{[]string{"Android"}, "Android"},
+	{[]string{"iPhone", "iPad", "iPod"}, "iOS"},
+	{[]string{"CrOS"}, "Chrome OS"},
+	{[]string{"Mac OS X", "Macintosh", "Mac_PowerPC"}, "Mac OS X"},
+	{[]string{"Windows CE"}, "Windows CE"},
+	{[]string{"Windows", "Win98", "Win95", "WinNT"}, "Windows"},
+	{[]string{"BlackBerry", "BB10", "RIM Tablet OS"}, "BlackBerry"},
+	{[]string{"Symbian", "Series60", "S60"}, "Symbian"},
+	{[]string{"FreeBSD", "freebsd"}, "FreeBSD"},
+	{[]string{"OpenBSD"}, "OpenBSD"},
+	{[]string{"NetBSD"}, "NetBSD"},
+	{[]string{"SunOS"}, "Solaris"},
+	{[]string{"OS/2"}, "OS/2"},
+	{[]string{"PalmOS", "PalmSource"}, "Palm OS"},
+	{[]string{"Linux", "X11"}, "Linux"},
+}
+
+var (
+	uaBotTokens    = []string{"bot", "Bot", "crawler", "Crawler", "spider", "Spider", "Slurp", "externalhit", "http://", "https://"}
+	uaTabletTokens = []string{"iPad", "Tablet", "PlayBook", "Kindle", "Silk/"}
+	uaMobileTokens = []string{"Mobile", "Mobi", "iPhone", "iPod", "MIDP", "Symbian", "Series60", "BlackBerry",
+		"BB10", "Opera Mini", "Windows CE", "IEMobile", "Windows Phone", "PalmOS", "DoCoMo", "UP.Browser", "UP.Link"}
+	uaDesktopSystems = map[string]bool{
+		"Windows": true, "Mac OS X": true, "Linux": true, "Chrome OS": true, "FreeBSD": true,
+		"OpenBSD": true, "NetBSD": true, "Solaris": true, "OS/2": true,
+	}
+)
+
+func containsAny(s string, tokens []string) bool {
+	for _, token := range tokens {
+		if strings.Contains(s, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// versionAfter - version which follows token in s, e.g. "9.0" of "MSIE 9.0;"
+func versionAfter(s, token string) string {
+	start := strings.Index(s, token)
+	if start < 0 {
+		return ""
+	}
+	s = s[start+len(token):]
+	end := strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '.' || r == '_')
+	})
+	if end < 0 {
+		end = len(s)
+	}
+	return s[:end]
+}
+
+// productToken - name and version of the first product which is not Mozilla
+// or an engine, for libraries and browsers which have no rule, e.g. Lynx/2.8.5
+func productToken(s string) (name, version string) {
+	for _, field := range strings.Fields(s) {
+		slash := strings.IndexByte(field, '/')
+		// urls of bots are not products
+		if slash <= 0 || strings.ContainsAny(field[:slash], "();:") {
+			continue
+		}
+		switch field[:slash] {
+		case "Mozilla", "AppleWebKit", "Gecko", "KHTML", "Presto", "Version", "Mobile",
+			"Profile", "profile", "Configuration", "configuration":
+			continue
+		}
+		return field[:slash], versionAfter(field, "/")
+	}
+	return "", ""
+}
+
+// ParseUserAgent - family, version, OS and device class of browser string,
+// unknown browsers are "Other"
+func ParseUserAgent(s string) UserAgent {
+	ua := UserAgent{Family: "Other", OS: UnknownOS, Device: DeviceOther}
+	for _, rule := range uaFamilies {
+		if !strings.Contains(s, rule.token) || rule.requires != "" && !strings.Contains(s, rule.requires) {
+			continue
+		}
+		ua.Family = rule.family
+		if rule.versionToken != "" {
+			ua.Version = versionAfter(s, rule.versionToken)
+		}
+		if ua.Version == "" {
+			ua.Version = versionAfter(s, rule.token)
+		}
+		break
+	}
+	if ua.Family == "Other" {
+		if name, version := productToken(s); name != "" {
+			ua.Family, ua.Version = name, version
+		}
+	}
+
+	for _, system := range uaSystems {
+		if containsAny(s, system.tokens) {
+			ua.OS = system.os
+			break
+		}
+	}
+
+	switch {
+	case containsAny(s, uaBotTokens):
+		ua.Device = DeviceBot
+	case containsAny(s, uaTabletTokens) || ua.OS == "Android" && !strings.Contains(s, "Mobile"):
+		ua.Device = DeviceTablet
+	case containsAny(s, uaMobileTokens) || ua.OS == "Android" || ua.OS == "iOS":
+		ua.Device = DeviceMobile
+	case uaDesktopSystems[ua.OS]:
+		ua.Device = DeviceDesktop
+	}
+	return ua
+}
+
+// compareVersion - -1, 0 or 1 as version is less, equal or greater than want,
+// compared by components of want only, so 9.0.1 equals 9 and 9.0
+func compareVersion(version string, want []int) int {
+	for _, w := range want {
+		n, digits := 0, 0
+		for digits < len(version) && version[digits] >= '0' && version[digits] <= '9' {
+			n = n*10 + int(version[digits]-'0')
+			digits++
+		}
+		version = version[digits:]
+		// the rest of a component, e.g. "rel" of 5rel, and the dot
+		if end := strings.IndexByte(version, '.'); end >= 0 {
+			version = version[end+1:]
+		} else {
+			version = ""
+		}
+		switch {
+		case n < w:
+			return -1
+		case n > w:
+			return 1
+		}
+	}
+	return 0
+}
+
+// defaultUACacheSize - dumps repeat the same few thousand browser strings
+const defaultUACacheSize = 4096
+
+// UACache - ParseUserAgent results of repeated browser strings, safe for
+// concurrent use. Once full it starts over, so it never grows past size.
+type UACache struct {
+	mu    sync.RWMutex
+	size  int
+	items map[string]UserAgent
+}
+
+func NewUACache(size int) *UACache {
+	if size < 1 {
+		size = 1
+	}
+	return &UACache{size: size, items: make(map[string]UserAgent, size)}
+}
+
+// Parse - ParseUserAgent of s, cached
+func (c *UACache) Parse(s string) UserAgent {
+	c.mu.RLock()
+	ua, ok := c.items[s]
+	c.mu.RUnlock()
+	if ok {
+		return ua
+	}
+	return c.store(s)
+}
+
+// ParseBytes - Parse which does not allocate for browsers parsed already
+func (c *UACache) ParseBytes(b []byte) UserAgent {
+	c.mu.RLock()
+	ua, ok := c.items[string(b)]
+	c.mu.RUnlock()
+	if ok {
+		return ua
+	}
+	return c.store(string(b))
+}
+
+func (c *UACache) store(s string) UserAgent {
+	ua := ParseUserAgent(s)
+	c.mu.Lock()
+	if len(c.items) >= c.size {
+		c.items = make(map[string]UserAgent, c.size)
+	}
+	c.items[s] = ua
+	c.mu.Unlock()
+	return ua
+}
+
+// Len - number of cached browser strings
+func (c *UACache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.items)
+}
+
+// uaMatcher - "IE < 9 on Android device mobile", empty parts match anything
+type uaMatcher struct {
+	// family - "" for any
+	family  string
+	cmp     string
+	version []int
+	os      string
+	device  DeviceClass
+}
+
+func (m *uaMatcher) match(ua UserAgent) bool {
+	if m.family != "" && !strings.EqualFold(m.family, ua.Family) {
+		return false
+	}
+	if m.os != "" && !strings.EqualFold(m.os, ua.OS) {
+		return false
+	}
+	if m.device != "" && m.device != ua.Device {
+		return false
+	}
+	if m.cmp == "" {
+		return true
+	}
+	if ua.Version == "" {
+		// unknown version is neither less nor greater than anything
+		return false
+	}
+	c := compareVersion(ua.Version, m.version)
+	switch m.cmp {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	case "!=":
+		return c != 0
+	default:
+		return c == 0
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseUserAgent(t *testing.T) {
+	cases := []struct {
+		src string
+		ua  UserAgent
+	}{
+		{"Mozilla/4.0 (compatible; MSIE 6.0; Windows CE; IEMobile 7.11)",
+			UserAgent{"IE Mobile", "7.11", "Windows CE", DeviceMobile}},
+		{"Mozilla/5.0 (compatible; MSIE 9.0; Windows NT 6.1; Trident/5.0)",
+			UserAgent{"IE", "9.0", "Windows", DeviceDesktop}},
+		{"Mozilla/5.0 (Windows NT 10.0; WOW64; Trident/7.0; MATBJS; rv:11.0) like Gecko",
+			UserAgent{"IE", "11.0", "Windows", DeviceDesktop}},
+		{"Mozilla/5.0 (Linux; Android 4.3; SPH-L710 Build/JSS15J) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/32.0.1700.99 Mobile Safari/537.36",
+			UserAgent{"Chrome", "32.0.1700.99", "Android", DeviceMobile}},
+		{"Mozilla/5.0 (Linux; U; Android 2.2; en-us; SCH-I800 Build/FROYO) AppleWebKit/533.1 (KHTML, like Gecko) Version/4.0 Mobile Safari/533.1",
+			UserAgent{"Android Browser", "4.0", "Android", DeviceMobile}},
+		{"Mozilla/5.0 (Android; Linux armv7l; rv:10.0.1) Gecko/20100101 Firefox/10.0.1 Fennec/10.0.1",
+			UserAgent{"Firefox", "10.0.1", "Android", DeviceTablet}},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 9_2 like Mac OS X) AppleWebKit/601.1.46 (KHTML, like Gecko) Version/9.0 Mobile/13C75 Safari/601.1",
+			UserAgent{"Safari", "9.0", "iOS", DeviceMobile}},
+		{"Opera/9.80 (Android; Opera Mini/7.5.33361/31.1543; U; en) Presto/2.8.119 Version/11.1010",
+			UserAgent{"Opera Mini", "7.5.33361", "Android", DeviceTablet}},
+		{"Opera/9.80 (X11; FreeBSD 8.1-RELEASE i386; Edition Next) Presto/2.12.388 Version/12.10",
+			UserAgent{"Opera", "12.10", "FreeBSD", DeviceDesktop}},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_10_5) AppleWebKit/600.8.9 (KHTML, like Gecko) Maxthon/4.5.2",
+			UserAgent{"Maxthon", "4.5.2", "Mac OS X", DeviceDesktop}},
+		{"Lynx/2.8.5rel.1 libwww-FM/2.14 SSL-MM/1.4.1 GNUTLS/0.8.12",
+			UserAgent{"Lynx", "2.8.5rel.1", UnknownOS, DeviceOther}},
+		{"Mozilla/5.0 (compatible; Googlebot/2.1;  http://www.google.com/bot.html)",
+			UserAgent{"Googlebot", "2.1", UnknownOS, DeviceBot}},
+		{"AdsBot-Google ( http://www.google.com/adsbot.html)",
+			UserAgent{"Other", "", UnknownOS, DeviceBot}},
+		{"Mozilla/4.0 (compatible; Dillo 3.0)",
+			UserAgent{"Other", "", UnknownOS, DeviceOther}},
+		{"", UserAgent{"Other", "", UnknownOS, DeviceOther}},
+	}
+	for _, c := range cases {
+		if ua := ParseUserAgent(c.src); ua != c.ua {
+			t.Errorf("%s:\nexpected %+v\ngot      %+v", c.src, c.ua, ua)
+		}
+	}
+}
+
+func TestCompareVersion(t *testing.T) {
+	cases := []struct {
+		version string
+		want    []int
+		cmp     int
+	}{
+		{"8.0", []int{9}, -1},
+		{"9.0", []int{9}, 0},
+		{"9.0.1", []int{9, 0}, 0},
+		{"10.0", []int{9}, 1},
+		{"9", []int{9, 1}, -1},
+		{"2.8.5rel.1", []int{2, 8, 5, 1}, 0},
+		{"32.0.1700.99", []int{32, 0, 1701}, -1},
+	}
+	for _, c := range cases {
+		if cmp := compareVersion(c.version, c.want); cmp != c.cmp {
+			t.Errorf("%s vs %v: expected %d, got %d", c.version, c.want, c.cmp, cmp)
+		}
+	}
+}
+
+func TestUACache(t *testing.T) {
+	cache := NewUACache(10)
+	wg := &sync.WaitGroup{}
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				ua := fmt.Sprintf("Mozilla/4.0 (compatible; MSIE %d.0; Windows NT 5.1)", i%20)
+				if got := cache.ParseBytes([]byte(ua)); got != ParseUserAgent(ua) {
+					t.Errorf("%s: cache returned %+v", ua, got)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if cache.Len() > 10 {
+		t.Errorf("cache grew to %d", cache.Len())
+	}
+
+	browser := []byte("Mozilla/4.0 (compatible; MSIE 6.0; Windows CE; IEMobile 7.11)")
+	cache.ParseBytes(browser)
+	if allocs := testing.AllocsPerRun(100, func() { cache.ParseBytes(browser) }); allocs != 0 {
+		t.Errorf("cached browser allocates %v times", allocs)
+	}
+}
+
+func TestUserAgentQuery(t *testing.T) {
+	cases := []struct {
+		src   string
+		match func(ua UserAgent) bool
+	}{
+		{`IE < 9 on Windows`, func(ua UserAgent) bool {
+			return ua.Family == "IE" && ua.Version != "" && compareVersion(ua.Version, []int{9}) < 0 && ua.OS == "Windows"
+		}},
+		{`any on Android device tablet`, func(ua UserAgent) bool {
+			return ua.OS == "Android" && ua.Device == DeviceTablet
+		}},
+		{`firefox >= 3.5 ON "Mac OS X"`, func(ua UserAgent) bool {
+			return ua.Family == "Firefox" && ua.Version != "" && compareVersion(ua.Version, []int{3, 5}) >= 0 && ua.OS == "Mac OS X"
+		}},
+		{`any device bot`, func(ua UserAgent) bool {
+			return ua.Device == DeviceBot
+		}},
+	}
+	for _, c := range cases {
+		q := MustParseQuery(c.src)
+		expected := []string{}
+		for i, line := range readLines(t) {
+			u := &User{}
+			if err := u.UnmarshalJSON(line); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			for _, browser := range u.Browsers {
+				if c.match(ParseUserAgent(browser)) {
+					expected = append(expected, fmt.Sprintf(`{"index":%d,`, i))
+					break
+				}
+			}
+		}
+		if len(expected) == 0 {
+			t.Errorf("%s: no users in the dataset, pick another query", c.src)
+		}
+
+		for _, workers := range []int{1, 4} {
+			out := new(bytes.Buffer)
+			if err := ParallelSearch(NewResultWriter(out, FormatJSONLines, EmailNone), filePath, q, workers, nil); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			found := strings.Split(strings.TrimSpace(out.String()), "\n")
+			if len(found) != len(expected) {
+				t.Errorf("%s: expected %d users, got %d", c.src, len(expected), len(found))
+				continue
+			}
+			for i := range found {
+				if !strings.HasPrefix(found[i], expected[i]) {
+					t.Errorf("%s: expected %s..., got %s", c.src, expected[i], found[i])
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkFastUserAgent(b *testing.B) {
+	q := MustParseQuery(`IE < 9 on Windows OR any on Android`)
+	for i := 0; i < b.N; i++ {
+		FastSearchFile(NewResultWriter(ioutil.Discard, FormatSummary, EmailNone), filePath, q, nil)
+	}
+}
@@ -3,16 +3,14 @@ package main
 // код писать тут
 import (
 	"encoding/json"
-	"encoding/xml"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
-	"os"
-	"sort"
 	"strconv"
 	"testing"
 	"time"
+
+	"hw4_test_coverage/searchserver"
 )
 
 const (
@@ -23,16 +21,15 @@ const (
 	ConstTriggerGoodRequestBadBody
 )
 
-type UserXmlRow struct {
-	Id     int    `xml:"id"`
-	Name   string `xml:"first_name"`
-	Age    int    `xml:"age"`
-	About  string `xml:"about"`
-	Gender string `xml:"gender"`
-}
+// testData - dataset.xml, loaded once for every test server
+var testData = mustLoadDataset("./dataset.xml")
 
-type UsersXml struct {
-	Rows []UserXmlRow `xml:"row"`
+func mustLoadDataset(path string) *searchserver.Dataset {
+	data, err := searchserver.LoadDataset(path)
+	if err != nil {
+		panic(err)
+	}
+	return data
 }
 
 func triggerServerErrors(w http.ResponseWriter, r *http.Request, orderBy int) {
@@ -68,106 +65,15 @@ func triggerServerErrors(w http.ResponseWriter, r *http.Request, orderBy int) {
 	}
 }
 
+// SearchServer - searchserver with good_token, order_by out of -1..1
+// triggers errors the real one never makes
 func SearchServer(w http.ResponseWriter, r *http.Request) {
-	if r.Header.Get("AccessToken") != "good_token" {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
-
 	orderBy, err := strconv.Atoi(r.FormValue("order_by"))
-	if err != nil {
-		resp, err := json.Marshal(SearchErrorResponse{"ErrorBadOrderField"})
-		if err != nil {
-			fmt.Println("can not pack result json:", err)
-			return
-		}
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write(resp)
-		return
-	}
-
-	//trigger error behaviour
-	if orderBy > 1 || orderBy < -1 {
+	if err == nil && (orderBy > 1 || orderBy < -1) && r.Header.Get("AccessToken") == "good_token" {
 		triggerServerErrors(w, r, orderBy)
 		return
 	}
-
-	orderField := r.FormValue("order_field")
-	if orderField == "" {
-		orderField = "Name"
-	}
-	if orderField != "Id" && orderField != "Age" && orderField != "Name" {
-		resp, err := json.Marshal(SearchErrorResponse{"ErrorBadOrderField"})
-		if err != nil {
-			fmt.Println("can not pack result json:", err)
-			return
-		}
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write(resp)
-		return
-	}
-
-	dataFile, err := os.Open("./dataset.xml")
-	if err != nil {
-		fmt.Println("can not open file:", err)
-		return
-	}
-	defer dataFile.Close()
-
-	var data UsersXml
-	dataByte, err := ioutil.ReadAll(dataFile)
-	if err != nil {
-		fmt.Println("can not read file:", err)
-		return
-	}
-	err = xml.Unmarshal(dataByte, &data)
-	if err != nil {
-		fmt.Println("can not unpack result json:", err)
-		return
-	}
-
-	//sorting
-	if orderBy != OrderByAsIs {
-		if orderBy == OrderByAsc {
-			switch orderField {
-			case "Id":
-				sort.Slice(data.Rows, func(i, j int) bool { return data.Rows[i].Id < data.Rows[j].Id })
-			case "Age":
-				sort.Slice(data.Rows, func(i, j int) bool { return data.Rows[i].Age < data.Rows[j].Age })
-			case "Name":
-				sort.Slice(data.Rows, func(i, j int) bool { return data.Rows[i].Name < data.Rows[j].Name })
-			}
-		} else if orderBy == OrderByDesc {
-			switch orderField {
-			case "Id":
-				sort.Slice(data.Rows, func(i, j int) bool { return data.Rows[i].Id > data.Rows[j].Id })
-			case "Age":
-				sort.Slice(data.Rows, func(i, j int) bool { return data.Rows[i].Age > data.Rows[j].Age })
-			case "Name":
-				sort.Slice(data.Rows, func(i, j int) bool { return data.Rows[i].Name > data.Rows[j].Name })
-			}
-		}
-	}
-
-	offset, err := strconv.Atoi(r.FormValue("offset"))
-	if err != nil {
-		fmt.Println("can not convert offset to int: ", err)
-		return
-	}
-
-	limit, err := strconv.Atoi(r.FormValue("limit"))
-	if err != nil {
-		fmt.Println("can not convert limit to int: ", err)
-		return
-	}
-
-	resp, err := json.Marshal(data.Rows[offset:limit])
-	if err != nil {
-		fmt.Println("can not pack result json:", err)
-		return
-	}
-
-	w.Write(resp)
+	searchserver.NewServer(testData, "good_token").ServeHTTP(w, r)
 }
 
 func TestFindUsersBadToken(t *testing.T) {
@@ -270,3 +176,28 @@ func TestFindUsers(t *testing.T) {
 		}
 	}
 }
+
+func TestFindUsersResults(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(SearchServer))
+	defer server.Close()
+	client := SearchClient{
+		AccessToken: "good_token",
+		URL:         server.URL,
+	}
+
+	resp, err := client.FindUsers(SearchRequest{Limit: 25, Offset: 30, Query: "", OrderField: "Id", OrderBy: OrderByAsc})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.NextPage || len(resp.Users) != 5 || resp.Users[0].Id != 30 {
+		t.Errorf("unexpected last page: %+v", resp)
+	}
+
+	resp, err = client.FindUsers(SearchRequest{Limit: 1, Offset: 0, Query: "Boyd", OrderField: "", OrderBy: OrderByAsIs})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.NextPage || len(resp.Users) != 1 || resp.Users[0].Name != "Boyd Wolf" {
+		t.Errorf("unexpected users: %+v", resp)
+	}
+}
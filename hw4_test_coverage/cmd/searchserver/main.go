@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"hw4_test_coverage/searchserver"
+)
+
+// main - runs the search service SearchClient talks to, from
+// $GOPATH/src/hw4_test_coverage:
+//
+//	go run ./cmd/searchserver -addr :8080 -dataset dataset.xml -token secret
+func main() {
+	addr := flag.String("addr", ":8080", "address to listen on")
+	dataset := flag.String("dataset", "./dataset.xml", "users dataset")
+	token := flag.String("token", os.Getenv("SEARCH_ACCESS_TOKEN"), "AccessToken clients must send, $SEARCH_ACCESS_TOKEN by default")
+	flag.Parse()
+	if *token == "" {
+		fmt.Fprintln(os.Stderr, "-token is required")
+		os.Exit(2)
+	}
+
+	data, err := searchserver.LoadDataset(*dataset)
+	if err != nil {
+		log.Fatalf("can not load dataset: %s", err)
+	}
+	log.Printf("%d users loaded from %s, listening on %s", data.Len(), *dataset, *addr)
+	log.Fatal(http.ListenAndServe(*addr, searchserver.NewServer(data, *token)))
+}
@@ -0,0 +1,253 @@
+// Package searchserver - users search service SearchClient.FindUsers talks to:
+//
+//	GET /?query=...&order_field=Id|Age|Name&order_by=-1|0|1&limit=N&offset=N
+//	AccessToken: <token>
+//
+// Users which Name or About contain query are sorted by order_field, Name if
+// empty, ascending for order_by -1, descending for 1 and in dataset order for
+// 0. Offset users are skipped, at most limit of the rest are returned as a
+// JSON array of users. Errors are {"Error": "..."} with 400 for bad
+// parameters, 401 for a wrong token and 500 if the response can not be sent.
+package searchserver
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Order directions of order_by
+const (
+	OrderByAsc  = -1
+	OrderByAsIs = 0
+	OrderByDesc = 1
+)
+
+// Errors of error responses, SearchClient recognizes ErrorBadOrderField
+const (
+	ErrorBadAccessToken = "ErrorBadAccessToken"
+	ErrorBadOrderField  = "ErrorBadOrderField"
+	ErrorBadOrderBy     = "ErrorBadOrderBy"
+	ErrorBadLimit       = "ErrorBadLimit"
+	ErrorBadOffset      = "ErrorBadOffset"
+	ErrorInternal       = "ErrorInternal"
+)
+
+// OrderFields - fields users may be sorted by
+var OrderFields = []string{"Id", "Age", "Name"}
+
+// User - what the service returns, Name is first_name and last_name
+type User struct {
+	Id     int
+	Name   string
+	Age    int
+	About  string
+	Gender string
+}
+
+// SearchErrorResponse - body of 4xx and 5xx responses
+type SearchErrorResponse struct {
+	Error string
+}
+
+// Error - request the service can not answer, Code is one of Error* constants
+type Error struct {
+	Code string
+}
+
+func (e *Error) Error() string {
+	return e.Code
+}
+
+type xmlRow struct {
+	Id        int    `xml:"id"`
+	FirstName string `xml:"first_name"`
+	LastName  string `xml:"last_name"`
+	Age       int    `xml:"age"`
+	About     string `xml:"about"`
+	Gender    string `xml:"gender"`
+}
+
+// Dataset - users loaded once, read-only, so any number of requests may
+// share it. Every order is sorted when loading, requests only filter.
+type Dataset struct {
+	users []User
+	// asc, desc - positions in users sorted by order field, users with
+	// equal fields stay in dataset order in both
+	asc, desc map[string][]int
+}
+
+// LoadDataset - reads dataset.xml at path
+func LoadDataset(path string) (*Dataset, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var data struct {
+		Rows []xmlRow `xml:"row"`
+	}
+	if err := xml.NewDecoder(file).Decode(&data); err != nil {
+		return nil, err
+	}
+	users := make([]User, 0, len(data.Rows))
+	for _, row := range data.Rows {
+		users = append(users, User{
+			Id:     row.Id,
+			Name:   row.FirstName + " " + row.LastName,
+			Age:    row.Age,
+			About:  row.About,
+			Gender: row.Gender,
+		})
+	}
+	return NewDataset(users), nil
+}
+
+// NewDataset - dataset of users in given order, users are not copied
+func NewDataset(users []User) *Dataset {
+	ds := &Dataset{users: users, asc: map[string][]int{}, desc: map[string][]int{}}
+	for _, field := range OrderFields {
+		less := userLess(field)
+		asc := make([]int, len(users))
+		for i := range asc {
+			asc[i] = i
+		}
+		desc := append([]int(nil), asc...)
+		sort.SliceStable(asc, func(i, j int) bool { return less(users[asc[i]], users[asc[j]]) })
+		sort.SliceStable(desc, func(i, j int) bool { return less(users[desc[j]], users[desc[i]]) })
+		ds.asc[field], ds.desc[field] = asc, desc
+	}
+	return ds
+}
+
+func userLess(field string) func(a, b User) bool {
+	switch field {
+	case "Id":
+		return func(a, b User) bool { return a.Id < b.Id }
+	case "Age":
+		return func(a, b User) bool { return a.Age < b.Age }
+	default:
+		return func(a, b User) bool { return a.Name < b.Name }
+	}
+}
+
+// Len - number of users
+func (ds *Dataset) Len() int {
+	return len(ds.users)
+}
+
+// Find - users matching query in requested order, [offset, offset+limit) of them
+func (ds *Dataset) Find(query, orderField string, orderBy, limit, offset int) ([]User, error) {
+	if orderField == "" {
+		orderField = "Name"
+	}
+	order, ok := ds.asc[orderField]
+	if !ok {
+		return nil, &Error{ErrorBadOrderField}
+	}
+	switch orderBy {
+	case OrderByAsc:
+	case OrderByDesc:
+		order = ds.desc[orderField]
+	case OrderByAsIs:
+		order = nil
+	default:
+		return nil, &Error{ErrorBadOrderBy}
+	}
+	if limit < 0 {
+		return nil, &Error{ErrorBadLimit}
+	}
+	if offset < 0 {
+		return nil, &Error{ErrorBadOffset}
+	}
+
+	found := []User{}
+	for i := range ds.users {
+		if len(found) == limit {
+			break
+		}
+		u := &ds.users[i]
+		if order != nil {
+			u = &ds.users[order[i]]
+		}
+		if query != "" && !strings.Contains(u.Name, query) && !strings.Contains(u.About, query) {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		found = append(found, *u)
+	}
+	return found, nil
+}
+
+// Server - http.Handler of the service over a dataset
+type Server struct {
+	data  *Dataset
+	token string
+}
+
+// NewServer - requests must have AccessToken header equal to token
+func NewServer(data *Dataset, token string) *Server {
+	return &Server{data: data, token: token}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		status = http.StatusInternalServerError
+		body, _ = json.Marshal(SearchErrorResponse{ErrorInternal})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// intParam - integer form value, def if missing
+func intParam(r *http.Request, name string, def int, code string) (int, error) {
+	value := r.FormValue(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, &Error{code}
+	}
+	return n, nil
+}
+
+func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("AccessToken") != srv.token {
+		writeJSON(w, http.StatusUnauthorized, SearchErrorResponse{ErrorBadAccessToken})
+		return
+	}
+
+	users, err := srv.find(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, SearchErrorResponse{err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, users)
+}
+
+func (srv *Server) find(r *http.Request) ([]User, error) {
+	orderBy, err := intParam(r, "order_by", OrderByAsIs, ErrorBadOrderBy)
+	if err != nil {
+		return nil, err
+	}
+	limit, err := intParam(r, "limit", srv.data.Len(), ErrorBadLimit)
+	if err != nil {
+		return nil, err
+	}
+	offset, err := intParam(r, "offset", 0, ErrorBadOffset)
+	if err != nil {
+		return nil, err
+	}
+	return srv.data.Find(r.FormValue("query"), r.FormValue("order_field"), orderBy, limit, offset)
+}
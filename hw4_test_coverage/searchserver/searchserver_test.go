@@ -0,0 +1,150 @@
+package searchserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+var testUsers = []User{
+	{Id: 2, Name: "Boyd Wolf", Age: 22, About: "Nulla cillum"},
+	{Id: 0, Name: "Ann Lee", Age: 30, About: "likes Wolf"},
+	{Id: 1, Name: "Jack Smith", Age: 22, About: "Lorem"},
+}
+
+func ids(users []User) []int {
+	result := []int{}
+	for _, u := range users {
+		result = append(result, u.Id)
+	}
+	return result
+}
+
+func equalIds(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestLoadDataset(t *testing.T) {
+	ds, err := LoadDataset("../dataset.xml")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if ds.Len() != 35 {
+		t.Errorf("expected 35 users, got %d", ds.Len())
+	}
+	users, _ := ds.Find("", "Id", OrderByAsc, 1, 0)
+	if len(users) != 1 || users[0].Name != "Boyd Wolf" || users[0].Age != 22 || users[0].Gender != "male" {
+		t.Errorf("unexpected first user %+v", users)
+	}
+
+	if _, err := LoadDataset("no_such_dataset.xml"); !os.IsNotExist(err) {
+		t.Errorf("expected not exist error, got %v", err)
+	}
+}
+
+func TestFind(t *testing.T) {
+	ds := NewDataset(testUsers)
+	testCases := []struct {
+		query, orderField      string
+		orderBy, limit, offset int
+		expected               []int
+	}{
+		{"", "", OrderByAsIs, 10, 0, []int{2, 0, 1}},
+		{"", "", OrderByAsc, 10, 0, []int{0, 2, 1}},
+		{"", "Name", OrderByDesc, 10, 0, []int{1, 2, 0}},
+		{"", "Id", OrderByAsc, 10, 0, []int{0, 1, 2}},
+		{"", "Id", OrderByDesc, 10, 0, []int{2, 1, 0}},
+		// equal ages stay in dataset order both ways
+		{"", "Age", OrderByAsc, 10, 0, []int{2, 1, 0}},
+		{"", "Age", OrderByDesc, 10, 0, []int{0, 2, 1}},
+		{"Wolf", "Id", OrderByAsc, 10, 0, []int{0, 2}},
+		{"Lorem", "", OrderByAsIs, 10, 0, []int{1}},
+		{"nobody", "", OrderByAsIs, 10, 0, []int{}},
+		{"", "Id", OrderByAsc, 1, 1, []int{1}},
+		{"", "Id", OrderByAsc, 0, 0, []int{}},
+		{"", "Id", OrderByAsc, 10, 5, []int{}},
+	}
+	for caseNum, item := range testCases {
+		users, err := ds.Find(item.query, item.orderField, item.orderBy, item.limit, item.offset)
+		if err != nil {
+			t.Errorf("[%d] unexpected error: %s", caseNum, err)
+			continue
+		}
+		if !equalIds(ids(users), item.expected) {
+			t.Errorf("[%d] expected %v, got %v", caseNum, item.expected, ids(users))
+		}
+	}
+}
+
+func TestFindErrors(t *testing.T) {
+	ds := NewDataset(testUsers)
+	testCases := []struct {
+		orderField             string
+		orderBy, limit, offset int
+		expected               string
+	}{
+		{"About", OrderByAsc, 1, 0, ErrorBadOrderField},
+		{"", 2, 1, 0, ErrorBadOrderBy},
+		{"", OrderByAsc, -1, 0, ErrorBadLimit},
+		{"", OrderByAsc, 1, -1, ErrorBadOffset},
+	}
+	for caseNum, item := range testCases {
+		_, err := ds.Find("", item.orderField, item.orderBy, item.limit, item.offset)
+		if e, ok := err.(*Error); !ok || e.Code != item.expected {
+			t.Errorf("[%d] expected %s, got %v", caseNum, item.expected, err)
+		}
+	}
+}
+
+func TestServer(t *testing.T) {
+	server := httptest.NewServer(NewServer(NewDataset(testUsers), "good_token"))
+	defer server.Close()
+
+	testCases := []struct {
+		token, params string
+		status        int
+		expected      []int
+		err           string
+	}{
+		{"good_token", "", http.StatusOK, []int{2, 0, 1}, ""},
+		{"good_token", "?query=Wolf&order_field=Id&order_by=-1&limit=1&offset=1", http.StatusOK, []int{2}, ""},
+		{"bad_token", "", http.StatusUnauthorized, nil, ErrorBadAccessToken},
+		{"good_token", "?order_field=About", http.StatusBadRequest, nil, ErrorBadOrderField},
+		{"good_token", "?order_by=x", http.StatusBadRequest, nil, ErrorBadOrderBy},
+		{"good_token", "?limit=x", http.StatusBadRequest, nil, ErrorBadLimit},
+		{"good_token", "?offset=-1", http.StatusBadRequest, nil, ErrorBadOffset},
+	}
+	for caseNum, item := range testCases {
+		req, _ := http.NewRequest("GET", server.URL+item.params, nil)
+		req.Header.Set("AccessToken", item.token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("[%d] unexpected error: %s", caseNum, err)
+		}
+		if resp.StatusCode != item.status {
+			t.Errorf("[%d] expected status %d, got %d", caseNum, item.status, resp.StatusCode)
+		}
+		if item.err != "" {
+			errResp := SearchErrorResponse{}
+			if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil || errResp.Error != item.err {
+				t.Errorf("[%d] expected error %s, got %+v %v", caseNum, item.err, errResp, err)
+			}
+		} else {
+			var users []User
+			if err := json.NewDecoder(resp.Body).Decode(&users); err != nil || !equalIds(ids(users), item.expected) {
+				t.Errorf("[%d] expected %v, got %v %v", caseNum, item.expected, ids(users), err)
+			}
+		}
+		resp.Body.Close()
+	}
+}